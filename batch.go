@@ -0,0 +1,89 @@
+package table
+
+// Batch collects inserts, removes and deletes which Commit applies to a table
+// atomically. The batch is logged as one WAL record, and a SyncTable applies
+// it under one exclusive lock, so readers see either all of it or nothing.
+//
+// The mutations are applied in the order they were added, so a delete matches
+// the rows inserted before it by the batch. Consecutive inserts are appended
+// as one new bucket, as a single Insert would.
+// A Batch is not safe for concurrent use.
+type Batch struct {
+	ops    []op
+	commit func(o op) ([]RowID, error)
+}
+
+// Batch starts an empty batch of mutations of the table
+func (b *Table) Batch() *Batch {
+	return &Batch{commit: func(o op) ([]RowID, error) {
+		o = b.assign(o)
+		b.exec(o)
+		if err := b.walErr(); err != nil {
+			return nil, err
+		}
+		return o.batchIDs(), nil
+	}}
+}
+
+// Insert adds rows to insert to the batch. Holes are ignored.
+func (x *Batch) Insert(data [][]string) {
+	if n := len(x.ops); n > 0 && x.ops[n-1].kind == opInsert {
+		x.ops[n-1].rows = append(x.ops[n-1].rows, data...)
+		return
+	}
+	// the caller may reuse the slice before Commit
+	x.ops = append(x.ops, op{kind: opInsert, rows: append([][]string(nil), data...)})
+}
+
+// Remove adds the deletion of all rows which have string val in column col to the batch
+func (x *Batch) Remove(col int, val string) {
+	x.ops = append(x.ops, op{kind: opRemove, col: col, val: val})
+}
+
+// DeleteBy adds the deletion of all rows matching every (col→val) to the batch.
+// Panics if filters is nil or empty.
+func (x *Batch) DeleteBy(filters map[int]string) {
+	mustFilter("DeleteBy", filters)
+	// the caller may reuse the map before Commit
+	x.ops = append(x.ops, op{kind: opDeleteBy, filters: cloneFilters(filters)})
+}
+
+// Len returns the number of collected mutations, counting each inserted row
+func (x *Batch) Len() (n int) {
+	for _, o := range x.ops {
+		if o.kind == opInsert {
+			n += len(o.rows)
+		} else {
+			n++
+		}
+	}
+	return
+}
+
+// Commit applies the collected mutations atomically and empties the batch.
+// It returns the IDs of the inserted rows in the order they were added, the
+// zero RowID for holes and dropped rows, like Insert. If the attached WAL
+// rejects the batch, none of it is applied and the error of the WAL is returned.
+func (x *Batch) Commit() ([]RowID, error) {
+	if len(x.ops) == 0 {
+		return nil, nil
+	}
+	o := op{kind: opBatch, batch: x.ops}
+	x.Rollback()
+	return x.commit(o)
+}
+
+// Rollback discards the collected mutations. The table is left untouched.
+func (x *Batch) Rollback() {
+	x.ops = nil
+}
+
+// batchIDs returns the IDs assigned to the rows inserted by a batch op
+func (o op) batchIDs() (ids []RowID) {
+	for _, sub := range o.batch {
+		if sub.kind == opInsert {
+			ids = append(ids, sub.ids...)
+		}
+	}
+	return
+}
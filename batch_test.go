@@ -0,0 +1,110 @@
+package table
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{{"cup", "tasse"}, {"key", "clé"}, {"bank", "banque"}})
+
+	batch := tbl.Batch()
+	batch.Insert([][]string{{"cup", "verre"}, nil})
+	batch.Remove(0, "cup")
+	filters := map[int]string{1: "clé"}
+	batch.DeleteBy(filters)
+	filters[1] = "banque"
+	batch.Insert([][]string{{"cup", "bol"}})
+	batch.Insert([][]string{{"key", "touche"}})
+	if batch.Len() != 6 {
+		t.Errorf("Len = %d; want 6", batch.Len())
+	}
+	if got := tbl.GetAll(0, "cup"); len(got) != 1 {
+		t.Fatalf("batch applied before Commit: %q", got)
+	}
+	ids, err := batch.Commit()
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	// the mutations apply in call order, so the remove matches the cup inserted
+	// before it but not the one inserted after it
+	want := [][]string{{"bank", "banque"}, {"cup", "bol"}, {"key", "touche"}}
+	if got := tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All after Commit = %q; want %q", got, want)
+	}
+	if len(ids) != 4 || ids[0] == 0 || ids[1] != 0 || tbl.GetByID(ids[0]) != nil {
+		t.Errorf("Commit IDs = %v; want the removed cup, a hole and two rows", ids)
+	}
+	if got := tbl.GetByID(ids[3]); !reflect.DeepEqual(got, []string{"key", "touche"}) {
+		t.Errorf("GetByID(%d) = %q", ids[3], got)
+	}
+	if n := len(tbl.b); n != 3 {
+		t.Errorf("table has %d buckets; want the consecutive inserts in one", n)
+	}
+	if batch.Len() != 0 {
+		t.Errorf("Commit did not empty the batch")
+	}
+
+	batch.Insert([][]string{{"key", "clé"}})
+	batch.DeleteBy(map[int]string{0: "bank"})
+	batch.Rollback()
+	if ids, err := batch.Commit(); err != nil || ids != nil {
+		t.Fatalf("Commit of empty batch = %v, %v", ids, err)
+	}
+	if got := tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All after Rollback = %q; want %q", got, want)
+	}
+}
+
+func TestBatchWALClosed(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "table.wal"), WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl := &Table{}
+	tbl.AttachWAL(w)
+	tbl.Insert([][]string{{"cup", "tasse"}})
+	w.Close()
+
+	batch := tbl.Batch()
+	batch.Remove(0, "cup")
+	batch.Insert([][]string{{"key", "clé"}})
+	if _, err := batch.Commit(); err != ErrWALClosed {
+		t.Errorf("Commit = %v; want ErrWALClosed", err)
+	}
+	if got := tbl.All(); !reflect.DeepEqual(got, [][]string{{"cup", "tasse"}}) {
+		t.Errorf("rejected batch was applied: %q", got)
+	}
+}
+
+func TestSyncTableBatchAtomic(t *testing.T) {
+	var st SyncTable
+	st.Insert([][]string{{"0"}})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// each batch moves the single row on, so readers always see exactly one
+		for i := 1; i <= 200; i++ {
+			batch := st.Batch()
+			batch.Remove(0, string(rune('0'+(i-1)%10)))
+			batch.Insert([][]string{{string(rune('0' + i%10))}})
+			if _, err := batch.Commit(); err != nil {
+				t.Errorf("Commit: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		if got := st.All(); len(got) != 1 {
+			t.Fatalf("reader saw a partial batch: %q", got)
+		}
+	}
+	wg.Wait()
+	if got := st.All(); !reflect.DeepEqual(got, [][]string{{"0"}}) {
+		t.Errorf("All = %q; want [[0]]", got)
+	}
+}
@@ -1,16 +1,38 @@
 package table
 
-import quaternary "github.com/neurlang/quaternary/v1"
+import (
+	"math/bits"
+	"runtime"
+	"sync"
 
-//import "sync"
-//import "math/bits"
-//import "runtime"
+	quaternary "github.com/neurlang/quaternary/v1"
+)
 
 type bucket struct {
 	data  [][]string
 	index [][][]byte
 	//blooms  [][]byte
 	loglen int
+	// holes is the number of deleted or empty rows in data
+	holes int
+	// sorted holds, for the range columns, the row positions sorted by the
+	// column value, built by the first range query
+	sorted *sortedColumns
+	// norms are the normalizers of the indexed values, by column
+	norms []Normalizer
+	// ids are the row IDs, by position
+	ids []RowID
+	// packed are the rows and IDs of a mapped snapshot, read in place of
+	// data and ids
+	packed *packedRows
+	// byID holds the positions sorted by row ID, built by the first lookup by ID
+	byID *idOrder
+	// dead marks the deleted rows, data is never modified
+	dead bitmap
+	// deleted counts the indexed values of the deleted rows, by column
+	deleted map[cell]int
+	// shared is set while a View may read dead and deleted, which are then copied before a row is deleted
+	shared bool
 }
 
 func (b *bucket) filter(j, c int, val string) uint64 {
@@ -36,166 +58,207 @@ func (ret *bucket) presentBucket(col int, val string) bool {
 
 }
 
-/*
-	func newBucketfast(rows [][]string) *bucket {
-		// Initialize bucket and handle empty input
-		ret := &bucket{
-			data:  rows,
-			index: make(map[[2]int][]byte),
-		}
-		if len(rows) <= 1 {
-			ret.loglen = 0
-			return ret
-		}
-
-		// Compute number of bits
-		loglen := bits.Len(uint(len(rows) - 1))
-		ret.loglen = loglen
-
-		// Precompute masks
-		bitMasks := make([]uint64, loglen)
-		for b := 0; b < loglen; b++ {
-			bitMasks[b] = 1 << b
-		}
+// parallelRows is the row count from which newBucket builds the index on all CPUs
+var parallelRows = 1 << 16
 
-		// Sequential counter pass + collect all partials
-		type countKey struct {
-			b, x int
-			s    string
-		}
-		counter := make(map[countKey]int, len(rows)*len(rows[0])*loglen)
-		type partial struct {
-			ikey [2]int
-			key  string
-			bits uint64
-		}
-		parts := make([]partial, 0, len(rows)*len(rows[0])*loglen)
+// newBucketParallel builds the same bucket as newBucketSerial using all CPUs.
+// Every (column, value) pair is owned by one shard, which sees its cells in
+// row order, so the occurence counters and the filters come out identical.
+func newBucketParallel(rows [][]string, norms []Normalizer) (ret *bucket) {
+	if len(rows) <= 1 {
+		return newBucketSerial(rows, norms)
+	}
+	ret = &bucket{
+		data:   rows,
+		loglen: bits.Len(uint(len(rows) - 1)),
+		holes:  countHoles(rows),
+		norms:  norms,
+	}
+	workers := runtime.GOMAXPROCS(0)
+	shards := workers
 
-		for y, row := range rows {
-			rowMask := uint64(y)
-			for x, key := range row {
-				for b := 0; b < loglen; b++ {
-					ck := countKey{b, x, key}
-					cnt := counter[ck]
-					counter[ck] = cnt + 1
+	// Phase 1: route the cells of contiguous row chunks to their shards
+	type cell struct {
+		y, x int
+		key  string
+	}
+	chunk := (len(rows) + workers - 1) / workers
+	outbox := make([][][]cell, workers)
+	maxlens := make([]int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			outbox[w] = make([][]cell, shards)
+			start, end := w*chunk, (w+1)*chunk
+			if end > len(rows) {
+				end = len(rows)
+			}
+			for y := start; y < end; y++ {
+				if len(rows[y]) > maxlens[w] {
+					maxlens[w] = len(rows[y])
+				}
+				for x, key := range rows[y] {
+					key = ret.key(x, key)
+					s := shardOf(x, key, shards)
+					outbox[w][s] = append(outbox[w][s], cell{y, x, key})
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
 
-					ik := [2]int{cnt + 1, x}
-					var bitsVal uint64
-					if rowMask&bitMasks[b] != 0 {
-						bitsVal = bitMasks[b]
+	// Phase 2: each shard counts its cells in row order and collects the level maps
+	type countKey struct {
+		x int
+		s string
+	}
+	collections := make([]map[[2]int]map[string]uint64, shards)
+	levels := make([]int, shards)
+	for s := 0; s < shards; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			counter := make(map[countKey]int)
+			collection := make(map[[2]int]map[string]uint64)
+			for w := range outbox {
+				for _, c := range outbox[w][s] {
+					k := countKey{c.x, c.key}
+					cnt := counter[k] + 1
+					counter[k] = cnt
+					if cnt > levels[s] {
+						levels[s] = cnt
 					}
-					parts = append(parts, partial{ik, key, bitsVal})
+					intkey := [2]int{cnt, c.x}
+					if collection[intkey] == nil {
+						collection[intkey] = make(map[string]uint64)
+					}
+					collection[intkey][c.key] = uint64(c.y)
 				}
+				outbox[w][s] = nil
 			}
-		}
+			for k, w := range counter {
+				intkey := [2]int{0, k.x}
+				if collection[intkey] == nil {
+					collection[intkey] = make(map[string]uint64)
+				}
+				collection[intkey][k.s] = uint64(w - 1)
+			}
+			collections[s] = collection
+		}(s)
+	}
+	wg.Wait()
 
-		// Zero‑count entries
-		for k, tot := range counter {
-			ik := [2]int{0, k.x}
-			boolVal := uint64(tot-1) & bitMasks[k.b]
-			parts = append(parts, partial{ik, k.s, boolVal})
+	var maxlen, maxlevel = 0, ret.loglen
+	for w := range maxlens {
+		if maxlens[w] > maxlen {
+			maxlen = maxlens[w]
 		}
-
-		// Shard parts for parallel collection
-		nWorkers := runtime.GOMAXPROCS(0)
-		shardSize := (len(parts) + nWorkers - 1) / nWorkers
-		workerCols := make([]map[[2]int]map[string]uint64, nWorkers)
-		for i := range workerCols {
-			workerCols[i] = make(map[[2]int]map[string]uint64)
+	}
+	for s := range levels {
+		if levels[s] > maxlevel {
+			maxlevel = levels[s]
 		}
+	}
+	ret.index = make([][][]byte, maxlevel+1, maxlevel+1)
+	for i := range ret.index {
+		ret.index[i] = make([][]byte, maxlen, maxlen)
+	}
 
-		var wg sync.WaitGroup
-		wg.Add(nWorkers)
-		for w := 0; w < nWorkers; w++ {
-			go func(w int) {
-				defer wg.Done()
-				start := w * shardSize
-				if start >= len(parts) {
-					return // nothing to do
-				}
-				end := start + shardSize
-				if end > len(parts) {
-					end = len(parts)
+	// Phase 3: merge the shards of every filter and build the filters concurrently
+	tasks := make(chan [2]int, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for intkey := range tasks {
+				var size int
+				for _, collection := range collections {
+					size += len(collection[intkey])
 				}
-				cols := workerCols[w]
-				for _, p := range parts[start:end] {
-					m := cols[p.ikey]
-					if m == nil {
-						m = make(map[string]uint64)
-						cols[p.ikey] = m
+				val := make(map[string]uint64, size)
+				for _, collection := range collections {
+					for k, v := range collection[intkey] {
+						val[k] = v
 					}
-					m[p.key] |= p.bits
-				}
-			}(w)
-		}
-		wg.Wait()
-
-		// Merge workerCols into global
-		global := make(map[[2]int]map[string]uint64, len(workerCols))
-		for _, cols := range workerCols {
-			for ik, km := range cols {
-				gm := global[ik]
-				if gm == nil {
-					gm = make(map[string]uint64, len(km))
-					global[ik] = gm
-				}
-				for k, v := range km {
-					gm[k] |= v
 				}
+				ret.index[intkey[0]][intkey[1]] = quaternary.Make(val, byte(ret.loglen))
+			}
+		}()
+	}
+	var seen = make(map[[2]int]struct{})
+	for _, collection := range collections {
+		for intkey := range collection {
+			if _, ok := seen[intkey]; !ok {
+				seen[intkey] = struct{}{}
+				tasks <- intkey
 			}
 		}
+	}
+	close(tasks)
+	wg.Wait()
+	return
+}
 
-		// Phase 3: build quaternary filters in parallel
-		type task struct {
-			ikey [2]int
-			val  map[string]uint64
-		}
-		tasks := make(chan task, len(global))
-		results := make(chan struct {
-			ik [2]int
-			fs []byte
-		}, nWorkers)
+// shardOf assigns a (column, value) pair to one of n shards
+func shardOf(x int, key string, n int) int {
+	h := uint32(2166136261) ^ uint32(x)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
 
-		go func() {
-			for ik, val := range global {
-				tasks <- task{ik, val}
-			}
-			close(tasks)
-		}()
+// newBucket builds a bucket and its quaternary index, on all CPUs for large inputs
+func newBucket(rows [][]string) *bucket {
+	return newNormalizedBucket(rows, nil)
+}
 
-		wg.Add(nWorkers)
-		for w := 0; w < nWorkers; w++ {
-			go func() {
-				defer wg.Done()
-				for t := range tasks {
-					var fs = quaternary.Make(t.val, byte(loglen))
-					//for k, v := range t.val {
-						//println(&fs[0], t.ikey[0], t.ikey[1], k, v, "(", loglen, ")")
-					//}
-					results <- struct {
-						ik [2]int
-						fs []byte
-					}{t.ikey, fs}
-				}
-			}()
-		}
+// newNormalizedBucket builds a bucket whose index holds the values normalized by norms
+func newNormalizedBucket(rows [][]string, norms []Normalizer) *bucket {
+	if len(rows) >= parallelRows && runtime.GOMAXPROCS(0) > 1 {
+		return newBucketParallel(rows, norms)
+	}
+	return newBucketSerial(rows, norms)
+}
 
-		go func() {
-			wg.Wait()
-			close(results)
-		}()
+// bucketLayout is how a table indexes its buckets
+type bucketLayout struct {
+	// ranged are the columns whose sorted positions the buckets keep
+	ranged []int
+	// norms are the normalizers of the indexed values, by column
+	norms []Normalizer
+	// names are the names of norms, empty for anonymous normalizers
+	names []string
+}
 
-		for r := range results {
-			ret.index[r.ik] = r.fs
-		}
+// build builds a bucket of the rows with the given IDs, its quaternary index
+// and the sorted positions of the range columns
+func (l *bucketLayout) build(rows [][]string, ids []RowID) *bucket {
+	buck := newNormalizedBucket(rows, l.norms)
+	buck.setIDs(ids)
+	buck.sortColumns(l.ranged)
+	return buck
+}
 
-		return ret
-	}
-*/
-func newBucket(rows [][]string) (ret *bucket) {
+// rebuild builds the index of a bucket again, keeping its rows, IDs and deletions
+func (l *bucketLayout) rebuild(b *bucket) *bucket {
+	buck := l.build(b.rows(), b.idList())
+	buck.dead, buck.holes, buck.shared = b.dead, b.holes, b.shared
+	// the values may normalize differently now
+	buck.tally()
+	return buck
+}
+
+// newBucketSerial builds a bucket and its quaternary index on a single CPU
+func newBucketSerial(rows [][]string, norms []Normalizer) (ret *bucket) {
 	ret = &bucket{
 		data:   rows,
 		loglen: 0,
+		holes:  countHoles(rows),
+		norms:  norms,
 	}
 	if len(rows) <= 1 {
 		return
@@ -203,7 +266,6 @@ func newBucket(rows [][]string) (ret *bucket) {
 	for i := 0; 1<<i < len(rows); i++ {
 		ret.loglen++
 	}
-	ret.index = make([][][]byte, ret.loglen+1, ret.loglen+1)
 	var counter = make(map[struct {
 		b int
 		n int
@@ -211,6 +273,8 @@ func newBucket(rows [][]string) (ret *bucket) {
 	}]int)
 	var collection = make(map[[2]int]map[string]uint64)
 	var maxlen int
+	// a value repeated more than loglen times needs more levels
+	var levels = ret.loglen
 	for y := range rows {
 		if len(rows[y]) > maxlen {
 			maxlen = len(rows[y])
@@ -218,7 +282,7 @@ func newBucket(rows [][]string) (ret *bucket) {
 		for x := range rows[y] {
 			// do other stuff
 			var bval uint64
-			key := rows[y][x]
+			key := ret.key(x, rows[y][x])
 			for b := 0; b < ret.loglen; b++ {
 				cnt := counter[struct {
 					b int
@@ -231,6 +295,9 @@ func newBucket(rows [][]string) (ret *bucket) {
 					s string
 				}{b, x, key}]++
 				intkey := [2]int{cnt + 1, x}
+				if cnt+1 > levels {
+					levels = cnt + 1
+				}
 				boolval := uint64(y) & (uint64(1) << b)
 				bval |= boolval
 				//println(strkey, "=>", boolval)
@@ -241,6 +308,7 @@ func newBucket(rows [][]string) (ret *bucket) {
 			}
 		}
 	}
+	ret.index = make([][][]byte, levels+1, levels+1)
 	for i := range ret.index {
 		ret.index[i] = make([][]byte, maxlen, maxlen)
 	}
@@ -272,16 +340,14 @@ func (b *bucket) countExisting(col int, val string) (out int) {
 	return
 }
 func (b *bucket) count(col int, val string) (out int) {
-	if len(b.data) == 0 {
+	if b.size() == 0 {
 		return 0
 	}
+	val = b.key(col, val)
 	var pos int
 	pos = int(b.filter(1, col, val))
-	idx := pos % len(b.data)
-	if col >= len(b.data[idx]) {
-		return 0
-	}
-	if b.data[idx][col] != val {
+	idx := pos % b.size()
+	if v, ok := b.cell(idx, col); !ok || b.key(col, v) != val {
 		return 0
 	}
 	out = int(b.filter(0, col, val))
@@ -289,14 +355,32 @@ func (b *bucket) count(col int, val string) (out int) {
 	return
 }
 
+// liveCount is count without the deleted rows
+func (b *bucket) liveCount(col int, val string) int {
+	n := b.count(col, val)
+	if n > 0 && b.deleted != nil {
+		n -= b.deleted[cell{col, b.key(col, val)}]
+	}
+	return n
+}
+
+// all returns the rows of the bucket, with nil for the deleted ones
 func (b *bucket) all() (data [][]string) {
-	return b.data
+	if b.dead == nil {
+		return b.rows()
+	}
+	data = make([][]string, b.size())
+	for y := range data {
+		data[y] = b.row(y)
+	}
+	return
 }
 
 func (b *bucket) getAll(col int, val string) (data [][]string) {
-	if len(b.data) == 0 {
+	if b.size() == 0 {
 		return nil
 	}
+	val = b.key(col, val)
 	cnt := b.countExisting(col, val)
 	if cnt == 0 {
 		return nil
@@ -305,17 +389,20 @@ func (b *bucket) getAll(col int, val string) (data [][]string) {
 		var pos int
 		pos = int(b.filter(j, col, val))
 		//println(key, pos)
-		fetched := b.data[pos%len(b.data)]
-		if col < len(fetched) && fetched[col] == val {
+		fetched := b.row(pos % b.size())
+		if col < len(fetched) && b.key(col, fetched[col]) == val {
 			data = append(data, fetched)
 		}
 	}
 	return
 }
-func (b *bucket) remove(col int, val string) {
-	if len(b.data) == 0 {
+
+// remove deletes the rows which have val in column col and returns their positions
+func (b *bucket) remove(col int, val string) (removed []int) {
+	if b.size() == 0 {
 		return
 	}
+	val = b.key(col, val)
 	cnt := b.countExisting(col, val)
 	if cnt == 0 {
 		return
@@ -323,19 +410,22 @@ func (b *bucket) remove(col int, val string) {
 	for j := 1; j <= cnt; j++ {
 		var pos int
 		pos = int(b.filter(j, col, val))
-		idx := pos % len(b.data)
+		idx := pos % b.size()
 		//println(key, pos)
-		fetched := b.data[idx]
-		if col < len(fetched) && fetched[col] == val {
-			b.data[idx] = nil
+		fetched := b.row(idx)
+		if col < len(fetched) && b.key(col, fetched[col]) == val {
+			b.punch(idx)
+			removed = append(removed, idx)
 		}
 	}
 	return
 }
+
 func (b *bucket) get(col int, val string) (data []string) {
-	if len(b.data) == 0 {
+	if b.size() == 0 {
 		return nil
 	}
+	val = b.key(col, val)
 	cnt := b.countExisting(col, val)
 	if cnt == 0 {
 		return nil
@@ -344,11 +434,21 @@ func (b *bucket) get(col int, val string) (data []string) {
 		var pos int
 		pos = int(b.filter(j, col, val))
 		//println(key, pos)
-		fetched := b.data[pos%len(b.data)]
-		if col < len(fetched) && fetched[col] == val {
+		fetched := b.row(pos % b.size())
+		if col < len(fetched) && b.key(col, fetched[col]) == val {
 			data = fetched
 			break
 		}
 	}
 	return
 }
+
+// countHoles counts the empty rows
+func countHoles(rows [][]string) (n int) {
+	for _, row := range rows {
+		if len(row) == 0 {
+			n++
+		}
+	}
+	return
+}
@@ -1,6 +1,9 @@
 package table
 
 import (
+	"math/rand"
+	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -32,3 +35,56 @@ func TestSanityBucket(t *testing.T) {
 		panic("fail")
 	}
 }
+
+func TestBucketRepeatedValue(t *testing.T) {
+	// a value repeated more often than the bucket has index bits
+	rows := [][]string{{"a", "1"}, {"a", "2"}, {"a", "3"}, {"b", "4"}, {"a", "5"}}
+	b := newBucket(rows)
+	if b.countExisting(0, "a") != 4 {
+		t.Fatalf("countExisting(a) = %d; want 4", b.countExisting(0, "a"))
+	}
+	if got := b.getAll(0, "a"); len(got) != 4 || got[3][1] != "5" {
+		t.Fatalf("getAll(a) = %v", got)
+	}
+}
+
+func TestNewBucketParallelIdentical(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	rnd := rand.New(rand.NewSource(7))
+	for _, n := range []int{2, 3, 17, 1000, 5000} {
+		rows := make([][]string, n)
+		for y := range rows {
+			if rnd.Intn(20) == 0 {
+				continue // hole
+			}
+			rows[y] = make([]string, 1+rnd.Intn(4))
+			for x := range rows[y] {
+				// few distinct values, so most of them repeat
+				rows[y][x] = randString(rnd, 1)
+			}
+		}
+		serial := newBucketSerial(rows, nil)
+		parallel := newBucketParallel(rows, nil)
+		if serial.loglen != parallel.loglen || serial.holes != parallel.holes {
+			t.Fatalf("%d rows: loglen/holes %d/%d vs %d/%d", n, serial.loglen, serial.holes, parallel.loglen, parallel.holes)
+		}
+		if !reflect.DeepEqual(serial.index, parallel.index) {
+			t.Fatalf("%d rows: parallel index differs from the serial one", n)
+		}
+	}
+}
+
+// BenchmarkNewBucket compares the serial and the parallel index construction
+func BenchmarkNewBucket(b *testing.B) {
+	data := sampleData(20000, 4)
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = newBucketSerial(data, nil)
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = newBucketParallel(data, nil)
+		}
+	})
+}
@@ -7,7 +7,7 @@ import (
 // getBy returns all raw matches for every (col→val), including nil holes.
 // It checks row contents, but is free to return holes (nil rows)
 func (b *bucket) getBy(q map[int]string) [][]string {
-	if q == nil || len(q) == 0 || len(b.data) == 0 {
+	if q == nil || len(q) == 0 || b.size() == 0 {
 		return nil
 	}
 
@@ -17,6 +17,7 @@ func (b *bucket) getBy(q map[int]string) [][]string {
 	}
 	cls := make([]clause, 0, len(q))
 	for c, v := range q {
+		v = b.key(c, v)
 		cnt := b.countExisting(c, v)
 		if cnt == 0 {
 			return nil
@@ -30,7 +31,7 @@ func (b *bucket) getBy(q map[int]string) [][]string {
 		return len(cls[i].val) > len(cls[j].val)
 	})
 
-	n := len(b.data)
+	n := b.size()
 	first := cls[0]
 	posList := make([]int, 0, first.cnt)
 	// seed positions via index
@@ -48,7 +49,7 @@ func (b *bucket) getBy(q map[int]string) [][]string {
 	// - for non-nil, ensure every clause is satisfied in-row
 	var result [][]string
 	for _, idx := range posList {
-		row := b.data[idx]
+		row := b.row(idx)
 		if row == nil {
 			// hole: emit as-is
 			result = append(result, nil)
@@ -57,7 +58,7 @@ func (b *bucket) getBy(q map[int]string) [][]string {
 		// verify all clauses
 		ok := true
 		for _, cl := range cls {
-			if cl.col >= len(row) || row[cl.col] != cl.val {
+			if cl.col >= len(row) || b.key(cl.col, row[cl.col]) != cl.val {
 				ok = false
 				break
 			}
@@ -72,29 +73,25 @@ func (b *bucket) getBy(q map[int]string) [][]string {
 	return result
 }
 
-// removeBy deletes all rows matching every (col→val).
-// Holes are simply overwritten with nil.
-func (b *bucket) removeBy(q map[int]string) {
-	if q == nil || len(q) == 0 || len(b.data) == 0 {
-		return
+// matchBy returns the positions of the live rows matching every (col→val)
+func (b *bucket) matchBy(q map[int]string) (positions []int) {
+	if q == nil || len(q) == 0 || b.size() == 0 {
+		return nil
 	}
 
-	// 1) Gather clauses & bail early
 	type clause struct {
-		col int
-		val string
-		cnt int
+		col, cnt int
+		val      string
 	}
 	cls := make([]clause, 0, len(q))
 	for c, v := range q {
+		v = b.key(c, v)
 		cnt := b.countExisting(c, v)
 		if cnt == 0 {
-			return
+			return nil
 		}
 		cls = append(cls, clause{col: c, val: v, cnt: cnt})
 	}
-
-	// 2) Sort by selectivity
 	sort.Slice(cls, func(i, j int) bool {
 		if cls[i].cnt != cls[j].cnt {
 			return cls[i].cnt < cls[j].cnt
@@ -102,38 +99,30 @@ func (b *bucket) removeBy(q map[int]string) {
 		return len(cls[i].val) > len(cls[j].val)
 	})
 
-	// 3) Seed & intersect exactly as getBy does, but keep indices
-	n := len(b.data)
+	n := b.size()
 	first := cls[0]
-	positions := make([]int, 0, first.cnt)
-	// seed from first clause
 	for j := 1; j <= first.cnt; j++ {
-		var bits int
-		bits = int(b.filter(j, first.col, first.val))
-		positions = append(positions, bits%n)
-	}
-	if len(positions) == 0 {
-		return
-	}
-	// intersect remaining clauses
-	for _, cl := range cls[1:] {
-		out := positions[:0]
-		for _, idx := range positions {
-			// use the same filter logic—no row content checks
-			var keep int
-			keep = int(b.filter(0, cl.col, cl.val))
-			if keep != 0 {
-				out = append(out, idx)
+		idx := int(b.filter(j, first.col, first.val)) % n
+		ok := !b.hole(idx)
+		for _, cl := range cls {
+			if !ok {
+				break
 			}
+			v, has := b.cell(idx, cl.col)
+			ok = has && b.key(cl.col, v) == cl.val
 		}
-		positions = out
-		if len(positions) == 0 {
-			return
+		if ok {
+			positions = append(positions, idx)
 		}
 	}
+	return
+}
 
-	// 4) Nullify exactly those slots
-	for _, idx := range positions {
-		b.data[idx] = nil
+// removeBy deletes all rows matching every (col→val) and returns their positions
+func (b *bucket) removeBy(q map[int]string) []int {
+	removed := b.matchBy(q)
+	for _, idx := range removed {
+		b.punch(idx)
 	}
+	return removed
 }
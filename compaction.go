@@ -0,0 +1,178 @@
+package table
+
+// CompactionPolicy makes a Table compact itself after a mutation crosses one
+// of the limits. Instead of rewriting the whole table like Compact, the policy
+// merges only the small buckets, LSM-style, and leaves the big ones alone.
+// Zero limits are disabled.
+type CompactionPolicy struct {
+	// Fanout enables size-tiered merging: whenever Fanout buckets share a
+	// size tier, they are merged into one bucket of the next tier
+	Fanout int
+	// MaxBuckets is the maximum number of buckets
+	MaxBuckets int
+	// MaxHoleFraction is the maximum fraction of holes in a single bucket.
+	// A bucket above it is rewritten without its holes.
+	MaxHoleFraction float64
+	// MaxSmallRows is the maximum total number of rows in small buckets
+	MaxSmallRows int
+	// SmallBucketRows is the row count below which a bucket is small.
+	// If zero, every bucket except the largest one is small.
+	SmallBucketRows int
+}
+
+// SetCompactionPolicy sets the automatic compaction policy, nil disables it
+func (b *Table) SetCompactionPolicy(p *CompactionPolicy) {
+	b.policy = p
+	b.autoCompact()
+}
+
+// autoCompact applies the compaction policy
+func (b *Table) autoCompact() {
+	p := b.policy
+	if p == nil {
+		return
+	}
+	defer b.compacted(b.gen)
+	if p.MaxHoleFraction > 0 {
+		out := b.b[:0]
+		for _, buck := range b.b {
+			if buck.holes > 0 && float64(buck.holes) > p.MaxHoleFraction*float64(buck.size()) {
+				b.gen++
+				rows, ids := buck.live(nil, nil)
+				if len(rows) == 0 {
+					continue
+				}
+				buck = *b.layout.build(rows, ids)
+			}
+			out = append(out, buck)
+		}
+		b.b = out
+	}
+	if p.Fanout > 1 {
+		b.mergeTiers(p.Fanout)
+	}
+	if p.MaxBuckets <= 0 && p.MaxSmallRows <= 0 {
+		return
+	}
+	small := p.small(b.b)
+	var smallRows, smallBuckets int
+	for i := range b.b {
+		if small(&b.b[i]) {
+			smallRows += b.b[i].size()
+			smallBuckets++
+		}
+	}
+	over := p.MaxBuckets > 0 && len(b.b) > p.MaxBuckets
+	if (over || (p.MaxSmallRows > 0 && smallRows > p.MaxSmallRows)) && smallBuckets > 1 {
+		b.merge(small)
+	}
+	if p.MaxBuckets > 0 && len(b.b) > p.MaxBuckets {
+		b.merge(func(*bucket) bool { return true })
+	}
+}
+
+// small returns the predicate selecting the small buckets
+func (p *CompactionPolicy) small(buckets []bucket) func(*bucket) bool {
+	if p.SmallBucketRows > 0 {
+		return func(buck *bucket) bool {
+			return buck.size() < p.SmallBucketRows
+		}
+	}
+	var largest *bucket
+	for i := range buckets {
+		if largest == nil || buckets[i].size() > largest.size() {
+			largest = &buckets[i]
+		}
+	}
+	return func(buck *bucket) bool {
+		return buck != largest
+	}
+}
+
+// merge replaces the buckets selected by pick with one bucket of their live
+// rows, placed where the first of them was
+func (b *Table) merge(pick func(*bucket) bool) {
+	var rows [][]string
+	var ids []RowID
+	var out = make([]bucket, 0, len(b.b))
+	var at = -1
+	for i := range b.b {
+		if !pick(&b.b[i]) {
+			out = append(out, b.b[i])
+			continue
+		}
+		rows, ids = b.b[i].live(rows, ids)
+		if at < 0 {
+			at = len(out)
+			out = append(out, bucket{})
+		}
+	}
+	if at < 0 {
+		return
+	}
+	b.gen++
+	if len(rows) == 0 {
+		b.b = append(out[:at], out[at+1:]...)
+		return
+	}
+	out[at] = *b.layout.build(rows, ids)
+	b.b = out
+}
+
+// CompactTiered merges buckets of similar size instead of rewriting the whole
+// table. Buckets are grouped into size tiers, powers of fanout, and every
+// fanout buckets of one tier are merged into a bucket of the next tier, so
+// each row is rewritten about once per tier rather than on every Compact.
+func (b *Table) CompactTiered(fanout int) {
+	if fanout < 2 {
+		fanout = 2
+	}
+	defer b.compacted(b.gen)
+	b.mergeTiers(fanout)
+}
+
+// mergeTiers merges fanout buckets of the lowest full tier until no tier is full
+func (b *Table) mergeTiers(fanout int) {
+	for {
+		var count = make(map[int]int)
+		var full = -1
+		for i := range b.b {
+			t := tier(b.b[i].size(), fanout)
+			count[t]++
+			if count[t] >= fanout && (full < 0 || t < full) {
+				full = t
+			}
+		}
+		if full < 0 {
+			return
+		}
+		var picked int
+		b.merge(func(buck *bucket) bool {
+			if picked < fanout && tier(buck.size(), fanout) == full {
+				picked++
+				return true
+			}
+			return false
+		})
+	}
+}
+
+// tier is the size tier of a bucket of n rows, the integer logarithm of n in base fanout
+func tier(n, fanout int) (t int) {
+	for n >= fanout {
+		n /= fanout
+		t++
+	}
+	return
+}
+
+// live appends the rows which are not holes to rows and their IDs to ids
+func (b *bucket) live(rows [][]string, ids []RowID) ([][]string, []RowID) {
+	for y := 0; y < b.size(); y++ {
+		if row := b.row(y); len(row) > 0 {
+			rows = append(rows, row)
+			ids = append(ids, b.id(y))
+		}
+	}
+	return rows, ids
+}
@@ -0,0 +1,120 @@
+package table
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCompactionPolicyMaxBuckets(t *testing.T) {
+	tbl := &Table{}
+	tbl.SetCompactionPolicy(&CompactionPolicy{MaxBuckets: 4})
+	for i := 0; i < 20; i++ {
+		tbl.Insert([][]string{{fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)}})
+		if len(tbl.b) > 4 {
+			t.Fatalf("after insert %d the table has %d buckets; want at most 4", i, len(tbl.b))
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if got := tbl.Get(0, fmt.Sprintf("k%d", i)); !reflect.DeepEqual(got, []string{fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)}) {
+			t.Errorf("Get(k%d) = %v", i, got)
+		}
+	}
+	if got := len(tbl.All()); got != 20 {
+		t.Errorf("All has %d rows; want 20", got)
+	}
+}
+
+func TestCompactionPolicyHoles(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert(sampleData(8, 2))
+	tbl.Insert([][]string{{"other", "bucket"}})
+	tbl.SetCompactionPolicy(&CompactionPolicy{MaxHoleFraction: 0.5})
+
+	for i := 0; i < 4; i++ {
+		tbl.Remove(0, fmt.Sprintf("C0-R%d", i))
+	}
+	if got := len(tbl.AllHoles()); got != 9 {
+		// exactly one half is still allowed
+		t.Fatalf("AllHoles has %d rows at the hole limit; want 9", got)
+	}
+	tbl.Remove(0, "C0-R4")
+	if got, live := len(tbl.AllHoles()), len(tbl.All()); got != live || live != 4 {
+		t.Fatalf("holes kept after crossing the limit: AllHoles=%d All=%d", got, live)
+	}
+	if got := tbl.Get(1, "C1-R7"); got == nil {
+		t.Errorf("Get(C1-R7) lost after hole compaction")
+	}
+}
+
+func TestCompactionPolicySmallBuckets(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert(sampleData(100, 3))
+	big := &tbl.b[0].data[0]
+	tbl.SetCompactionPolicy(&CompactionPolicy{MaxSmallRows: 5, SmallBucketRows: 10})
+
+	for i := 0; i < 12; i++ {
+		tbl.Insert([][]string{{fmt.Sprintf("s%d", i), "small", fmt.Sprintf("x%d", i)}})
+	}
+	if &tbl.b[0].data[0] != big {
+		t.Fatalf("the big bucket was rewritten by a small bucket merge")
+	}
+	var smallRows int
+	for _, buck := range tbl.b[1:] {
+		if len(buck.data) < 10 {
+			smallRows += len(buck.data)
+		}
+	}
+	if smallRows > 5 {
+		t.Fatalf("small buckets hold %d rows; want at most 5", smallRows)
+	}
+	if got := len(tbl.All()); got != 112 {
+		t.Fatalf("All has %d rows; want 112", got)
+	}
+	for i := 0; i < 12; i++ {
+		if got := tbl.Get(2, fmt.Sprintf("x%d", i)); got == nil {
+			t.Errorf("Get(x%d) lost after merging small buckets", i)
+		}
+	}
+}
+
+func TestCompactTiered(t *testing.T) {
+	const fanout = 4
+	tbl := &Table{}
+	tbl.SetCompactionPolicy(&CompactionPolicy{Fanout: fanout})
+	for i := 0; i < 200; i++ {
+		tbl.Insert([][]string{{fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)}})
+
+		// no size tier may hold fanout buckets after a mutation
+		tiers := make(map[int]int)
+		for _, buck := range tbl.b {
+			tiers[tier(len(buck.data), fanout)]++
+		}
+		for tr, n := range tiers {
+			if n >= fanout {
+				t.Fatalf("after insert %d tier %d has %d buckets", i, tr, n)
+			}
+		}
+	}
+	if len(tbl.b) > 3*(fanout-1) {
+		t.Errorf("table has %d buckets for 200 rows", len(tbl.b))
+	}
+	for i := 0; i < 200; i++ {
+		if got := tbl.Get(0, fmt.Sprintf("k%d", i)); len(got) != 2 || got[1] != fmt.Sprintf("v%d", i) {
+			t.Fatalf("Get(k%d) = %v", i, got)
+		}
+	}
+
+	// manual tiered compaction of a table without a policy
+	manual := &Table{}
+	for i := 0; i < 9; i++ {
+		manual.Insert([][]string{{fmt.Sprintf("m%d", i)}})
+	}
+	manual.CompactTiered(3)
+	if len(manual.b) != 1 {
+		t.Errorf("CompactTiered(3) left %d buckets of 9 single rows; want 1", len(manual.b))
+	}
+	if got := len(manual.All()); got != 9 {
+		t.Errorf("CompactTiered lost rows: %d", got)
+	}
+}
@@ -0,0 +1,77 @@
+package table
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	// ErrEmptyFilter is returned for nil or empty filters
+	ErrEmptyFilter = errors.New("table: filters must not be nil or empty")
+	// ErrNegativeColumn is returned for a filter on a negative column
+	ErrNegativeColumn = errors.New("table: negative column")
+	// ErrColumnRange is returned for a filter on a column beyond the table width
+	ErrColumnRange = errors.New("table: column beyond table width")
+)
+
+// ColumnError reports a filter column which the table cannot have
+type ColumnError struct {
+	// Col is the offending column
+	Col int
+	// Width is the table width the column was checked against
+	Width int
+	// Err is ErrNegativeColumn or ErrColumnRange
+	Err error
+}
+
+func (e *ColumnError) Error() string {
+	return e.Err.Error() + ": column " + strconv.Itoa(e.Col) + ", width " + strconv.Itoa(e.Width)
+}
+
+func (e *ColumnError) Unwrap() error {
+	return e.Err
+}
+
+// checkFilter validates filters against the schema or the table width.
+// An empty table has no width yet, so any non-negative column is accepted.
+func (t *Table) checkFilter(filters map[int]string) error {
+	if len(filters) == 0 {
+		return ErrEmptyFilter
+	}
+	width := t.Width()
+	if t.schema != nil {
+		width = len(t.schema.Columns)
+	}
+	for c := range filters {
+		if c < 0 {
+			return &ColumnError{Col: c, Width: width, Err: ErrNegativeColumn}
+		}
+		if width > 0 && c >= width {
+			return &ColumnError{Col: c, Width: width, Err: ErrColumnRange}
+		}
+	}
+	return nil
+}
+
+// Width returns the number of columns of the widest row in the table
+func (t *Table) Width() (width int) {
+	for i := range t.b {
+		if w := t.b[i].width(); w > width {
+			width = w
+		}
+	}
+	return
+}
+
+// width returns the number of columns of the widest row in the bucket
+func (b *bucket) width() (width int) {
+	if len(b.index) > 0 {
+		return len(b.index[0])
+	}
+	for y := 0; y < b.size(); y++ {
+		if n := b.cols(y); n > width {
+			width = n
+		}
+	}
+	return
+}
@@ -0,0 +1,217 @@
+package table
+
+import "sort"
+
+// Expr is a filter expression over (col, val) clauses, built with Eq, In,
+// And, Or and Not. Queries seed their candidates from the quaternary index
+// where the expression allows it, and fall back to scanning a bucket where
+// it does not, such as under a Not without an indexed sibling clause.
+type Expr interface {
+	// match reports whether a live row satisfies the expression
+	match(b *bucket, row []string) bool
+	// cost estimates the number of candidate rows in the bucket, or -1 if
+	// the expression cannot be seeded from the index
+	cost(b *bucket) int
+	// seed appends the candidate positions in the bucket, which are not verified
+	seed(b *bucket, pos []int) []int
+}
+
+// Eq matches rows which have string val in column col
+func Eq(col int, val string) Expr {
+	return eqExpr{col: col, val: val}
+}
+
+// In matches rows which have any of the strings vals in column col
+func In(col int, vals ...string) Expr {
+	return inExpr{col: col, vals: vals}
+}
+
+// And matches rows which match every expression. And() matches every row.
+func And(exprs ...Expr) Expr {
+	return andExpr(exprs)
+}
+
+// Or matches rows which match any expression. Or() matches no row.
+func Or(exprs ...Expr) Expr {
+	return orExpr(exprs)
+}
+
+// Not matches rows which do not match the expression
+func Not(expr Expr) Expr {
+	return notExpr{expr}
+}
+
+type eqExpr struct {
+	col int
+	val string
+}
+
+func (e eqExpr) match(b *bucket, row []string) bool {
+	return e.col >= 0 && e.col < len(row) && b.key(e.col, row[e.col]) == b.key(e.col, e.val)
+}
+
+func (e eqExpr) cost(b *bucket) int {
+	if e.col < 0 {
+		return 0
+	}
+	return b.countExisting(e.col, b.key(e.col, e.val))
+}
+
+func (e eqExpr) seed(b *bucket, pos []int) []int {
+	if e.col < 0 || b.size() == 0 {
+		return pos
+	}
+	val := b.key(e.col, e.val)
+	cnt := b.countExisting(e.col, val)
+	for j := 1; j <= cnt; j++ {
+		pos = append(pos, int(b.filter(j, e.col, val))%b.size())
+	}
+	return pos
+}
+
+type inExpr struct {
+	col  int
+	vals []string
+}
+
+func (e inExpr) match(b *bucket, row []string) bool {
+	if e.col < 0 || e.col >= len(row) {
+		return false
+	}
+	val := b.key(e.col, row[e.col])
+	for _, v := range e.vals {
+		if val == b.key(e.col, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e inExpr) cost(b *bucket) (cnt int) {
+	for _, v := range e.vals {
+		cnt += eqExpr{e.col, v}.cost(b)
+	}
+	return
+}
+
+func (e inExpr) seed(b *bucket, pos []int) []int {
+	for _, v := range e.vals {
+		pos = eqExpr{e.col, v}.seed(b, pos)
+	}
+	return pos
+}
+
+type andExpr []Expr
+
+func (e andExpr) match(b *bucket, row []string) bool {
+	for _, sub := range e {
+		if !sub.match(b, row) {
+			return false
+		}
+	}
+	return true
+}
+
+// cheapest returns the seedable clause with the fewest candidates, like getBy does for AND
+func (e andExpr) cheapest(b *bucket) (best Expr, cnt int) {
+	cnt = -1
+	for _, sub := range e {
+		c := sub.cost(b)
+		if c >= 0 && (cnt < 0 || c < cnt) {
+			best, cnt = sub, c
+		}
+	}
+	return
+}
+
+func (e andExpr) cost(b *bucket) int {
+	_, cnt := e.cheapest(b)
+	return cnt
+}
+
+func (e andExpr) seed(b *bucket, pos []int) []int {
+	if best, _ := e.cheapest(b); best != nil {
+		return best.seed(b, pos)
+	}
+	return pos
+}
+
+type orExpr []Expr
+
+func (e orExpr) match(b *bucket, row []string) bool {
+	for _, sub := range e {
+		if sub.match(b, row) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e orExpr) cost(b *bucket) (cnt int) {
+	for _, sub := range e {
+		c := sub.cost(b)
+		if c < 0 {
+			return -1
+		}
+		cnt += c
+	}
+	return
+}
+
+func (e orExpr) seed(b *bucket, pos []int) []int {
+	for _, sub := range e {
+		pos = sub.seed(b, pos)
+	}
+	return pos
+}
+
+type notExpr struct {
+	e Expr
+}
+
+func (e notExpr) match(b *bucket, row []string) bool {
+	return !e.e.match(b, row)
+}
+
+func (e notExpr) cost(b *bucket) int {
+	return -1
+}
+
+func (e notExpr) seed(b *bucket, pos []int) []int {
+	return pos
+}
+
+// getExpr returns the live rows of the bucket matching the expression, in bucket order
+func (b *bucket) getExpr(e Expr) (data [][]string) {
+	if b.size() == 0 {
+		return nil
+	}
+	if e.cost(b) < 0 {
+		for y := 0; y < b.size(); y++ {
+			if row := b.row(y); len(row) > 0 && e.match(b, row) {
+				data = append(data, row)
+			}
+		}
+		return
+	}
+	pos := e.seed(b, nil)
+	sort.Ints(pos)
+	for i, idx := range pos {
+		if i > 0 && pos[i-1] == idx {
+			continue
+		}
+		if row := b.row(idx); len(row) > 0 && e.match(b, row) {
+			data = append(data, row)
+		}
+	}
+	return
+}
+
+// QueryExpr finds all rows matching the filter expression, skipping any holes.
+// Returns nil for no matches.
+func (t *Table) QueryExpr(e Expr) (data [][]string) {
+	for i := range t.b {
+		data = append(data, t.b[i].getExpr(e)...)
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package table
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestQueryExpr(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{
+		{"u1", "admin", "active"},
+		{"u2", "member", "inactive"},
+		{"u3", "admin", "inactive"},
+	})
+	tbl.InsertHoles([][]string{{"u4", "member", "active"}, nil, {"u5", "guest", "active"}})
+	tbl.Insert([][]string{{"u6", "guest", "inactive"}, {"u7", "admin", "active"}})
+	tbl.Remove(0, "u7")
+
+	for _, tc := range []struct {
+		name string
+		expr Expr
+		want [][]string
+	}{
+		{"eq", Eq(1, "admin"), [][]string{{"u1", "admin", "active"}, {"u3", "admin", "inactive"}}},
+		{"in", In(1, "guest", "nobody"), [][]string{{"u5", "guest", "active"}, {"u6", "guest", "inactive"}}},
+		{"or", Or(Eq(0, "u2"), Eq(0, "u6")), [][]string{{"u2", "member", "inactive"}, {"u6", "guest", "inactive"}}},
+		{"and-not", And(Eq(2, "active"), Not(Eq(1, "admin"))), [][]string{{"u4", "member", "active"}, {"u5", "guest", "active"}}},
+		{"not", Not(In(2, "active", "inactive")), nil},
+		{"or-overlap", Or(Eq(1, "admin"), Eq(2, "inactive")), [][]string{
+			{"u1", "admin", "active"}, {"u2", "member", "inactive"}, {"u3", "admin", "inactive"}, {"u6", "guest", "inactive"}}},
+		{"empty-or", Or(), nil},
+		{"negative", Eq(-1, "u1"), nil},
+	} {
+		if got := tbl.QueryExpr(tc.expr); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: QueryExpr = %v; want %v", tc.name, got, tc.want)
+		}
+	}
+	if got := len(tbl.QueryExpr(And())); got != len(tbl.All()) {
+		t.Errorf("And() matched %d rows; want all %d", got, len(tbl.All()))
+	}
+}
+
+func randomExpr(rnd *rand.Rand, depth int) Expr {
+	val := func() string { return string('a' + byte(rnd.Intn(5))) }
+	if depth == 0 {
+		if rnd.Intn(3) == 0 {
+			return In(rnd.Intn(3), val(), val())
+		}
+		return Eq(rnd.Intn(3), val())
+	}
+	switch rnd.Intn(4) {
+	case 0:
+		return Not(randomExpr(rnd, depth-1))
+	case 1:
+		return Or(randomExpr(rnd, depth-1), randomExpr(rnd, depth-1))
+	default:
+		return And(randomExpr(rnd, depth-1), randomExpr(rnd, depth-1))
+	}
+}
+
+func TestQueryExprFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	tbl := &Table{}
+	for i := 0; i < 20; i++ {
+		rows := make([][]string, 1+rnd.Intn(30))
+		for y := range rows {
+			rows[y] = []string{randString(rnd, 1), randString(rnd, 1), randString(rnd, 1)}
+			for x := range rows[y] {
+				// narrow the alphabet so that clauses hit often
+				rows[y][x] = string('a' + rows[y][x][0]%4)
+			}
+		}
+		tbl.Insert(rows)
+		tbl.Remove(rnd.Intn(3), string('a'+byte(rnd.Intn(4))))
+	}
+	all := tbl.All()
+	for i := 0; i < 500; i++ {
+		e := randomExpr(rand.New(rand.NewSource(int64(i))), 3)
+		var want [][]string
+		for _, row := range all {
+			if e.match(&bucket{}, row) {
+				want = append(want, row)
+			}
+		}
+		if got := tbl.QueryExpr(e); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expr %#v: QueryExpr = %v; want %v", e, got, want)
+		}
+	}
+}
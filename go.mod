@@ -2,10 +2,13 @@ module github.com/neurlang/table
 
 go 1.18
 
-require github.com/neurlang/quaternary v0.2.4
+require (
+	github.com/neurlang/quaternary v0.2.4
+	golang.org/x/text v0.14.0
+)
 
 require (
 	github.com/klauspost/cpuid/v2 v2.2.3 // indirect
 	github.com/minio/sha256-simd v1.0.1 // indirect
-	golang.org/x/sys v0.0.0-20220704084225-05e143d24a9e // indirect
+	golang.org/x/sys v0.5.0 // indirect
 )
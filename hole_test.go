@@ -1,6 +1,7 @@
 package table
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 )
@@ -57,3 +58,87 @@ func TestHoleApis(t *testing.T) {
 		t.Errorf("QueryBy(row1) = %v; want nil", qDead)
 	}
 }
+
+func TestHoleBitmap(t *testing.T) {
+	rows := [][]string{{"cup", "tasse"}, {"key", "clé"}, {"bank", "banque"}, {"cup", "verre"}}
+	tbl := &Table{}
+	tbl.Insert(rows)
+	tbl.SetRangeColumns(1)
+	tbl.Remove(0, "cup")
+	tbl.DeleteBy(map[int]string{1: "clé"})
+
+	// the rows are left as they were inserted
+	buck := &tbl.b[0]
+	if !reflect.DeepEqual(buck.data, rows) {
+		t.Errorf("data after deletes = %q; want %q", buck.data, rows)
+	}
+	if buck.holes != 3 || !buck.hole(0) || buck.hole(2) {
+		t.Errorf("holes = %d, hole(0) = %v, hole(2) = %v", buck.holes, buck.hole(0), buck.hole(2))
+	}
+	want := [][]string{nil, nil, {"bank", "banque"}, nil}
+	if got := tbl.AllHoles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AllHoles = %q; want %q", got, want)
+	}
+	if got := tbl.GetAll(0, "cup"); got != nil {
+		t.Errorf("GetAll(cup) = %q; want none", got)
+	}
+	if got := tbl.QueryRange(1, "a", "z"); !reflect.DeepEqual(got, want[2:3]) {
+		t.Errorf("QueryRange = %q; want %q", got, want[2:3])
+	}
+
+	// the bitmap is saved, and kept by a re-index on load
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	for _, norm := range []Normalizer{nil, FoldCase} {
+		loaded := &Table{}
+		loaded.SetNormalizer(0, norm)
+		if _, err := loaded.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("ReadFrom: %v", err)
+		}
+		if got := loaded.AllHoles(); !reflect.DeepEqual(got, want) {
+			t.Errorf("loaded AllHoles = %q; want %q", got, want)
+		}
+		if got := loaded.b[0].data; !reflect.DeepEqual(got, rows) {
+			t.Errorf("loaded data = %q; want %q", got, rows)
+		}
+	}
+}
+
+func TestCountLive(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{{"cup", "tasse"}, {"cup", "verre"}, {"Cup", "bol"}, {"key", "clé"}})
+	tbl.Insert([][]string{{"cup", "tasse"}})
+	view := tbl.Snapshot()
+	tbl.DeleteBy(map[int]string{1: "tasse"})
+	tbl.Remove(0, "key")
+
+	counts := func(name string, count func(int, string) int, want ...int) {
+		t.Helper()
+		for i, q := range []string{"cup", "key", "Cup"} {
+			if got := count(0, q); got != want[i] {
+				t.Errorf("%s(%q) = %d; want %d", name, q, got, want[i])
+			}
+		}
+	}
+	counts("Count", tbl.Count, 1, 0, 1)
+	counts("CountHoles", tbl.CountHoles, 3, 1, 1)
+	counts("view Count", view.Count, 3, 1, 1)
+
+	// the counters follow the normalizers and survive snapshots
+	tbl.SetNormalizer(0, FoldCase)
+	counts("folded Count", tbl.Count, 2, 0, 2)
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := &Table{}
+	loaded.SetNormalizer(0, FoldCase)
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	counts("loaded Count", loaded.Count, 2, 0, 2)
+	tbl.Compact()
+	counts("compacted CountHoles", tbl.CountHoles, 2, 0, 2)
+}
@@ -0,0 +1,83 @@
+package table
+
+// The rows of a bucket are immutable once it is built. A delete only marks
+// the position in the dead bitmap of the bucket, so the deleted row stays
+// available to Views and to the index checks, and a hole is either a row
+// inserted empty or a dead one.
+
+// hole reports whether the row at position y is a hole
+func (b *bucket) hole(y int) bool {
+	return b.cols(y) == 0 || b.dead.has(y)
+}
+
+// cols returns the number of columns of the row at position y, even if it was deleted
+func (b *bucket) cols(y int) int {
+	if b.packed != nil {
+		return b.packed.cols(y)
+	}
+	return len(b.data[y])
+}
+
+// row returns the row at position y, or nil if it is a hole
+func (b *bucket) row(y int) []string {
+	if b.dead.has(y) {
+		return nil
+	}
+	return b.at(y)
+}
+
+// punch deletes the row at position y, leaving a hole. The dead bitmap and
+// counters are copied first if a View shares them, so the view keeps seeing
+// the row.
+func (b *bucket) punch(y int) {
+	if b.hole(y) {
+		return
+	}
+	if b.shared || b.dead == nil {
+		dead := make(bitmap, (b.size()+63)/64)
+		copy(dead, b.dead)
+		deleted := make(map[cell]int, len(b.deleted)+b.cols(y))
+		for k, n := range b.deleted {
+			deleted[k] = n
+		}
+		b.dead, b.deleted, b.shared = dead, deleted, false
+	}
+	b.dead.set(y)
+	b.holes++
+	for x, v := range b.at(y) {
+		b.deleted[cell{x, b.key(x, v)}]++
+	}
+}
+
+// cell is an indexed value of a column
+type cell struct {
+	col int
+	val string
+}
+
+// tally counts the indexed values of the deleted rows again
+func (b *bucket) tally() {
+	b.deleted = nil
+	if b.dead == nil {
+		return
+	}
+	b.deleted = make(map[cell]int)
+	for y := 0; y < b.size(); y++ {
+		if b.dead.has(y) {
+			for x, v := range b.at(y) {
+				b.deleted[cell{x, b.key(x, v)}]++
+			}
+		}
+	}
+}
+
+// bitmap is a set of row positions, one bit per row
+type bitmap []uint64
+
+func (m bitmap) has(y int) bool {
+	return y>>6 < len(m) && m[y>>6]&(1<<(y&63)) != 0
+}
+
+func (m bitmap) set(y int) {
+	m[y>>6] |= 1 << (y & 63)
+}
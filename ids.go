@@ -0,0 +1,198 @@
+package table
+
+import (
+	"sort"
+	"sync"
+)
+
+// RowID identifies a row of a table. IDs are assigned by Insert, InsertHoles
+// and Upsert, are never reused, and survive UpdateBy, compaction, snapshots
+// and WAL replay. The zero RowID is no row.
+type RowID uint64
+
+// assign gives the rows an insert op will insert new row IDs, unless the op
+// carries them already. Holes and rows not fitting the schema get no ID, nor
+// does the row of an upsert whose key matches a live row.
+func (b *Table) assign(o op) op {
+	switch o.kind {
+	case opInsert, opInsertHoles:
+	case opUpsert:
+		if o.ids == nil && b.matches(o.filters) {
+			o.ids = make([]RowID, len(o.rows))
+			return o
+		}
+	case opBatch:
+		batch := make([]op, len(o.batch))
+		for i, sub := range o.batch {
+			batch[i] = b.assign(sub)
+		}
+		o.batch = batch
+		return o
+	default:
+		return o
+	}
+	if o.ids != nil {
+		return o
+	}
+	o.ids = make([]RowID, len(o.rows))
+	for i, row := range o.rows {
+		if len(row) > 0 && b.schema.fits(row) {
+			b.next++
+			o.ids[i] = b.next
+		}
+	}
+	return o
+}
+
+// seen makes sure IDs inserted by a replayed op are not assigned again
+func (b *Table) seen(ids []RowID) []RowID {
+	for _, id := range ids {
+		if id > b.next {
+			b.next = id
+		}
+	}
+	return ids
+}
+
+// id returns the ID of the row at position y, or the zero RowID
+func (b *bucket) id(y int) RowID {
+	if b.packed != nil {
+		return b.packed.id(y)
+	}
+	if y < len(b.ids) {
+		return b.ids[y]
+	}
+	return 0
+}
+
+// numIDs returns the number of positions which have an ID
+func (b *bucket) numIDs() int {
+	if b.packed != nil {
+		return b.packed.len()
+	}
+	return len(b.ids)
+}
+
+// idList returns the IDs of the rows, by position
+func (b *bucket) idList() []RowID {
+	if b.packed == nil {
+		return b.ids
+	}
+	ids := make([]RowID, b.packed.len())
+	for y := range ids {
+		ids[y] = b.packed.id(y)
+	}
+	return ids
+}
+
+// idOrder are the positions of the rows of a bucket sorted by row ID. The IDs
+// of a bucket never change, so copies of the bucket share them, and
+// concurrent readers build them once.
+type idOrder struct {
+	once sync.Once
+	pos  []uint32
+}
+
+// setIDs sets the row IDs. Their sorted positions are built by the first
+// lookup by ID, so loading and mapping snapshots stay cheap.
+func (b *bucket) setIDs(ids []RowID) {
+	b.ids, b.byID = ids, &idOrder{}
+}
+
+// pack makes the bucket read its rows and IDs from p
+func (b *bucket) pack(p *packedRows) {
+	b.data, b.packed = nil, p
+	b.ids, b.byID = nil, &idOrder{}
+}
+
+// sortedByID returns the positions of the rows sorted by row ID, or nil if
+// the IDs are sorted already, as they are unless rows were merged or updated
+func (b *bucket) sortedByID() []uint32 {
+	o := b.byID
+	if o == nil {
+		return nil
+	}
+	o.once.Do(func() {
+		n := b.numIDs()
+		sorted := true
+		for y := 1; y < n && sorted; y++ {
+			sorted = b.id(y-1) <= b.id(y)
+		}
+		if sorted {
+			return
+		}
+		o.pos = make([]uint32, n)
+		for y := range o.pos {
+			o.pos[y] = uint32(y)
+		}
+		sort.Slice(o.pos, func(i, j int) bool {
+			return b.id(int(o.pos[i])) < b.id(int(o.pos[j]))
+		})
+	})
+	return o.pos
+}
+
+// find returns the position of the live row with the given ID, or -1
+func (b *bucket) find(id RowID) int {
+	byID := b.sortedByID()
+	at := func(i int) int {
+		if byID != nil {
+			return int(byID[i])
+		}
+		return i
+	}
+	n := b.numIDs()
+	for i := sort.Search(n, func(i int) bool { return b.id(at(i)) >= id }); i < n && b.id(at(i)) == id; i++ {
+		// an updated row leaves a hole with its ID behind
+		if y := at(i); !b.hole(y) {
+			return y
+		}
+	}
+	return -1
+}
+
+// GetByID loads the row with the given ID, or nil if it does not exist or was deleted
+func (t *Table) GetByID(id RowID) []string {
+	for i := range t.b {
+		if y := t.b[i].find(id); y >= 0 {
+			return t.b[i].at(y)
+		}
+	}
+	return nil
+}
+
+// DeleteByID deletes the row with the given ID, leaving a hole.
+// It reports whether the row existed.
+func (t *Table) DeleteByID(id RowID) bool {
+	n, _ := t.exec(op{kind: opDeleteByID, ids: []RowID{id}})
+	return n > 0
+}
+
+func (t *Table) deleteByID(ids []RowID) (n int) {
+	stone := t.tombstone(nil)
+	for _, id := range ids {
+		for i := range t.b {
+			if y := t.b[i].find(id); y >= 0 {
+				t.b[i].punch(y)
+				stone.add(&t.b[i], []int{y})
+				n++
+				break
+			}
+		}
+	}
+	t.bury(stone, EventDeleteByID)
+	return
+}
+
+// QueryByIDs finds the IDs of all rows matching every (col→val), skipping any holes.
+// Panics if filters is nil or empty.
+// Returns nil for no matches.
+func (t *Table) QueryByIDs(filters map[int]string) (ids []RowID) {
+	mustFilter("QueryByIDs", filters)
+	for i := range t.b {
+		for _, y := range t.b[i].matchBy(filters) {
+			ids = append(ids, t.b[i].id(y))
+		}
+	}
+	return
+}
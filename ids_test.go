@@ -0,0 +1,177 @@
+package table
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRowIDs(t *testing.T) {
+	tbl := &Table{}
+	ids := tbl.Insert([][]string{{"cup", "tasse"}, nil, {"cup", "tasse"}, {"cup", "verre"}})
+	if len(ids) != 4 || ids[1] != 0 || ids[0] == 0 || ids[0] == ids[2] || ids[2] == ids[3] {
+		t.Fatalf("Insert IDs = %v", ids)
+	}
+	holes := tbl.InsertHoles([][]string{nil, {"bank", "banque"}})
+	if holes[0] != 0 || holes[1] <= ids[3] {
+		t.Fatalf("InsertHoles IDs = %v", holes)
+	}
+
+	// 1. Identical rows are told apart
+	if got := tbl.QueryByIDs(map[int]string{1: "tasse"}); !reflect.DeepEqual(got, []RowID{ids[0], ids[2]}) {
+		t.Errorf("QueryByIDs(tasse) = %v; want %v", got, []RowID{ids[0], ids[2]})
+	}
+	if !tbl.DeleteByID(ids[0]) || tbl.DeleteByID(ids[0]) || tbl.DeleteByID(12345) {
+		t.Errorf("DeleteByID does not report deletion")
+	}
+	if got := tbl.GetAll(1, "tasse"); len(got) != 1 {
+		t.Errorf("DeleteByID deleted %d copies", 2-len(got))
+	}
+	if tbl.GetByID(ids[0]) != nil || tbl.GetByID(ids[2]) == nil {
+		t.Errorf("GetByID after DeleteByID = %q, %q", tbl.GetByID(ids[0]), tbl.GetByID(ids[2]))
+	}
+
+	// 2. IDs survive updates, Upsert, compaction and snapshots
+	tbl.UpdateBy(map[int]string{1: "verre"}, map[int]string{1: "bol"})
+	if got := tbl.GetByID(ids[3]); !reflect.DeepEqual(got, []string{"cup", "bol"}) {
+		t.Errorf("GetByID of updated row = %q", got)
+	}
+	tbl.Upsert(map[int]string{0: "coin"}, []string{"coin", "pièce"})
+	coin := tbl.QueryByIDs(map[int]string{0: "coin"})
+	tbl.Compact()
+	tbl.Insert([][]string{{"key", "clé"}})
+	tbl.CompactTiered(2)
+	check := func(name string, tbl *Table) {
+		t.Helper()
+		want := map[RowID][]string{
+			ids[0]: nil, ids[2]: {"cup", "tasse"}, ids[3]: {"cup", "bol"},
+			holes[1]: {"bank", "banque"}, coin[0]: {"coin", "pièce"},
+		}
+		for id, row := range want {
+			if got := tbl.GetByID(id); !reflect.DeepEqual(got, row) {
+				t.Errorf("%s: GetByID(%d) = %q; want %q", name, id, got, row)
+			}
+		}
+	}
+	check("compacted", tbl)
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := &Table{}
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	check("loaded", loaded)
+	// IDs are never reused
+	if again := loaded.Insert([][]string{{"new"}}); again[0] <= coin[0] {
+		t.Errorf("loaded table reuses ID %d", again[0])
+	}
+}
+
+func TestUpsertNoopID(t *testing.T) {
+	for _, name := range []string{"Table", "SyncTable"} {
+		var ins func([][]string) []RowID
+		var ups func(map[int]string, []string) bool
+		if name == "Table" {
+			tbl := &Table{}
+			ins, ups = tbl.Insert, tbl.Upsert
+		} else {
+			st := &SyncTable{}
+			ins, ups = st.Insert, st.Upsert
+		}
+		first := ins([][]string{{"cup", "tasse"}})
+		// a no-op Upsert does not consume an ID
+		for i := 0; i < 3; i++ {
+			if ups(map[int]string{0: "cup"}, []string{"cup", "verre"}) {
+				t.Fatalf("%s: Upsert inserted a duplicate of cup", name)
+			}
+		}
+		if next := ins([][]string{{"key", "clé"}}); next[0] != first[0]+1 {
+			t.Errorf("%s: Insert after no-op Upserts = ID %d; want %d", name, next[0], first[0]+1)
+		}
+	}
+}
+
+func TestRowIDsLoadLazy(t *testing.T) {
+	tbl := &Table{}
+	ids := tbl.Insert([][]string{{"cup", "tasse"}, {"key", "clé"}, {"bank", "banque"}})
+	// the updated row moves behind the others, so the IDs are out of order
+	tbl.UpdateBy(map[int]string{0: "cup"}, map[int]string{1: "bol"})
+	tbl.Compact()
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := &Table{}
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := loaded.b[0].byID.pos; got != nil {
+		t.Fatalf("loading sorted the IDs: %v", got)
+	}
+
+	// concurrent lookups build the sorted positions once
+	st := NewSyncTable(loaded)
+	want := map[RowID][]string{ids[0]: {"cup", "bol"}, ids[1]: {"key", "clé"}, ids[2]: {"bank", "banque"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id, row := range want {
+				if got := st.GetByID(id); !reflect.DeepEqual(got, row) {
+					t.Errorf("GetByID(%d) = %q; want %q", id, got, row)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	st.Read(func(tbl *Table) {
+		if tbl.b[0].byID.pos == nil {
+			t.Errorf("lookups did not sort the out of order IDs")
+		}
+	})
+}
+
+func TestRowIDsRecover(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(filepath.Join(dir, "table.wal"), WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl, _ := Recover(filepath.Join(dir, "table.tbl"), w)
+	walWorkload(tbl)
+	ids := tbl.Insert([][]string{{"u7", "guest", "active"}, {"u7", "guest", "active"}})
+	tbl.DeleteByID(ids[0])
+	w.Close()
+
+	w, _ = OpenWAL(filepath.Join(dir, "table.wal"), WALOptions{})
+	defer w.Close()
+	recovered, err := Recover(filepath.Join(dir, "table.tbl"), w)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if recovered.GetByID(ids[0]) != nil || recovered.GetByID(ids[1]) == nil {
+		t.Errorf("recovered GetByID = %q, %q", recovered.GetByID(ids[0]), recovered.GetByID(ids[1]))
+	}
+	if got, want := recovered.QueryByIDs(map[int]string{1: "guest"}), tbl.QueryByIDs(map[int]string{1: "guest"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered QueryByIDs = %v; want %v", got, want)
+	}
+}
+
+func TestRowIDsCompactAsync(t *testing.T) {
+	var st SyncTable
+	first := st.Insert([][]string{{"a"}, {"b"}})
+	done := st.CompactAsync()
+	// rows inserted during the rebuild are replayed with the same IDs
+	late := st.Insert([][]string{{"c"}})
+	<-done
+	for i, id := range append(first, late...) {
+		if got := st.GetByID(id); len(got) != 1 || got[0] != string(rune('a'+i)) {
+			t.Errorf("GetByID(%d) = %q after CompactAsync", id, got)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package table
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrMapUnsupported is returned by OpenMapped on platforms without mmap support
+var ErrMapUnsupported = errors.New("table: memory-mapped tables are not supported on this platform")
+
+// MappedTable is a read-only table which answers queries straight out of a
+// memory-mapped snapshot file. The rows, row IDs and quaternary filters are
+// read in place and a row is only decoded when it is loaded, so several
+// processes can share one table through the page cache.
+// Rows returned by a MappedTable are only valid until Close.
+type MappedTable struct {
+	t    *Table
+	data []byte
+}
+
+// OpenMapped maps the (preferably compacted) snapshot file at path read-only.
+// norms are the normalizers by column to look up with, see SetNormalizer.
+// They are anonymous, so a snapshot indexed by normalizers has its index
+// rebuilt in memory, see OpenMappedNamed.
+func OpenMapped(path string, norms ...Normalizer) (*MappedTable, error) {
+	return OpenMappedNamed(path, nil, norms...)
+}
+
+// OpenMappedNamed is OpenMapped with the names of the normalizers by column,
+// see SetNamedNormalizer. If the snapshot was indexed by normalizers of the
+// same names, its index is used as is, otherwise it is rebuilt in memory.
+func OpenMappedNamed(path string, names []string, norms ...Normalizer) (*MappedTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() == 0 {
+		_, err = decodeSnapshot(nil, nil, nil)
+		return nil, err
+	}
+	if int64(int(st.Size())) != st.Size() {
+		return nil, ErrSnapshotCorrupt
+	}
+	data, err := mmapFile(f, int(st.Size()))
+	if err != nil {
+		return nil, err
+	}
+	// names has an entry for every column of norms
+	named := make([]string, len(norms))
+	copy(named, names)
+	loaded, err := decodeSnapshot(data, norms, named)
+	if err != nil {
+		munmapFile(data)
+		return nil, err
+	}
+	return &MappedTable{t: loaded, data: data}, nil
+}
+
+// Close unmaps the snapshot file. Rows obtained from the table must not be used afterwards.
+func (m *MappedTable) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	m.t = &Table{}
+	data := m.data
+	m.data = nil
+	return munmapFile(data)
+}
+
+// Count counts the number of occurences of string val in column col,
+// not counting the deleted rows
+func (m *MappedTable) Count(col int, val string) int {
+	return m.t.Count(col, val)
+}
+
+// CountHoles counts the number of occurences of string val in column col, including the deleted rows
+func (m *MappedTable) CountHoles(col int, val string) int {
+	return m.t.CountHoles(col, val)
+}
+
+// GetAll loads all the rows which have string val in column col
+func (m *MappedTable) GetAll(col int, val string) [][]string {
+	return m.t.GetAll(col, val)
+}
+
+// Get loads arbitrary single row which does have string val in column col
+func (m *MappedTable) Get(col int, val string) []string {
+	return m.t.Get(col, val)
+}
+
+// UniqueKeys returns the unique keys of the table
+func (m *MappedTable) UniqueKeys() []UniqueKey {
+	return m.t.UniqueKeys()
+}
+
+// Validate reports the live rows violating the unique keys, or nil if there are none
+func (m *MappedTable) Validate() []*KeyError {
+	return m.t.Validate()
+}
+
+// Schema returns the schema recorded in the snapshot, or nil
+func (m *MappedTable) Schema() *Schema {
+	return m.t.Schema()
+}
+
+// QueryByName finds all rows matching every (column name→val), skipping any holes.
+// Panics if filters is nil or empty, or names a column not in the schema.
+func (m *MappedTable) QueryByName(filters map[string]string) [][]string {
+	return m.t.QueryByName(filters)
+}
+
+// QueryBy finds all rows matching every (col→val), skipping any holes.
+// Panics if filters is nil or empty.
+// Returns nil for no matches.
+func (m *MappedTable) QueryBy(filters map[int]string) [][]string {
+	return m.t.QueryBy(filters)
+}
+
+// QueryRange loads all the rows with lo <= value < hi in column col, sorted by the value
+func (m *MappedTable) QueryRange(col int, lo, hi string) [][]string {
+	return m.t.QueryRange(col, lo, hi)
+}
+
+// QueryPrefix loads all the rows whose value in column col starts with prefix, sorted by the value
+func (m *MappedTable) QueryPrefix(col int, prefix string) [][]string {
+	return m.t.QueryPrefix(col, prefix)
+}
+
+// Scan returns an iterator over all rows, skipping the deletion holes
+func (m *MappedTable) Scan() func(yield func(row []string) bool) {
+	return m.t.Scan()
+}
+
+// ScanBy returns an iterator over the rows matching every (col→val), skipping any holes
+func (m *MappedTable) ScanBy(filters map[int]string) func(yield func(row []string) bool) {
+	return m.t.ScanBy(filters)
+}
+
+// AllPage returns up to limit rows starting at cursor and the cursor of the next page
+func (m *MappedTable) AllPage(cursor string, limit int) ([][]string, string, error) {
+	return m.t.AllPage(cursor, limit)
+}
+
+// QueryByPage returns up to limit rows matching every (col→val) starting at
+// cursor and the cursor of the next page
+func (m *MappedTable) QueryByPage(filters map[int]string, cursor string, limit int) ([][]string, string, error) {
+	return m.t.QueryByPage(filters, cursor, limit)
+}
+
+// GetByID loads the row with the given ID, or nil if it does not exist or was deleted
+func (m *MappedTable) GetByID(id RowID) []string {
+	return m.t.GetByID(id)
+}
@@ -0,0 +1,164 @@
+package table
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpenMapped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dict.tbl")
+	tbl := snapshotSample()
+	tbl.Compact()
+	if err := tbl.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	m, err := OpenMapped(path)
+	if err == ErrMapUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("OpenMapped: %v", err)
+	}
+	defer m.Close()
+
+	if got, want := m.GetAll(0, "cup"), tbl.GetAll(0, "cup"); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll(cup) = %v; want %v", got, want)
+	}
+	if got := m.Get(2, "llave"); !reflect.DeepEqual(got, []string{"key", "clé", "llave"}) {
+		t.Errorf("Get(llave) = %v", got)
+	}
+	if got := m.Count(1, "pièce"); got != 2 {
+		t.Errorf("Count(pièce) = %d; want 2", got)
+	}
+	if got := m.QueryBy(map[int]string{1: "pièce", 2: "moneda"}); !reflect.DeepEqual(got, [][]string{{"coin", "pièce", "moneda"}}) {
+		t.Errorf("QueryBy(pièce+moneda) = %v", got)
+	}
+	if got := m.QueryBy(map[int]string{0: "bank"}); got != nil {
+		t.Errorf("QueryBy(bank) = %v; want nil", got)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := m.GetAll(0, "cup"); got != nil {
+		t.Errorf("GetAll after Close = %v; want nil", got)
+	}
+}
+
+func TestOpenMappedCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dict.tbl")
+	if err := snapshotSample().Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": image[:len(image)/2],
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := OpenMapped(p); err == nil {
+			m.Close()
+			t.Errorf("%s: OpenMapped succeeded", name)
+		}
+	}
+}
+
+func TestOpenMappedInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dict.tbl")
+	tbl := snapshotSample()
+	if err := tbl.SetRangeColumns(1); err != nil {
+		t.Fatal(err)
+	}
+	ids := tbl.Insert([][]string{{"tea", "thé", "té"}, {"sea", "mer", "mar"}})
+	if err := tbl.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	m, err := OpenMapped(path)
+	if err == ErrMapUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("OpenMapped: %v", err)
+	}
+	defer m.Close()
+
+	// the rows and IDs stay in the mapping until they are loaded
+	for i := range m.t.b {
+		if b := &m.t.b[i]; b.packed == nil || b.data != nil || b.ids != nil {
+			t.Fatalf("bucket %d decoded at open", i)
+		}
+	}
+	if got, want := m.t.All(), tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All = %v; want %v", got, want)
+	}
+	for _, id := range ids {
+		if got, want := m.GetByID(id), tbl.GetByID(id); !reflect.DeepEqual(got, want) {
+			t.Errorf("GetByID(%d) = %v; want %v", id, got, want)
+		}
+	}
+	if got, want := m.QueryRange(1, "m", "t"), tbl.QueryRange(1, "m", "t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryRange = %v; want %v", got, want)
+	}
+	if got := m.Count(1, "pièce"); got != 2 {
+		t.Errorf("Count(pièce) = %d; want 2", got)
+	}
+	page, _, err := m.AllPage("", 3)
+	if want, _, _ := tbl.AllPage("", 3); err != nil || !reflect.DeepEqual(page, want) {
+		t.Errorf("AllPage = %v, %v; want %v", page, err, want)
+	}
+
+	// a table loaded from the snapshot owns its rows
+	var loaded Table
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for i := range loaded.b {
+		if loaded.b[i].packed != nil {
+			t.Fatalf("bucket %d still packed after Load", i)
+		}
+	}
+}
+
+func TestPackedRowsValid(t *testing.T) {
+	var buf bytes.Buffer
+	w := &snapshotWriter{w: bufio.NewWriter(&buf)}
+	w.packed(&bucket{data: [][]string{{"cup", "tasse"}, nil, {"key"}}, ids: []RowID{1, 2, 3}})
+	w.w.Flush()
+	s := &snapshotReader{buf: buf.Bytes()}
+	p := s.packed()
+	if s.err != nil || p == nil {
+		t.Fatalf("packed: %v", s.err)
+	}
+	if got := p.row(0); !reflect.DeepEqual(got, []string{"cup", "tasse"}) {
+		t.Errorf("row(0) = %v", got)
+	}
+	if got, ok := p.cell(0, 1); !ok || got != "tasse" {
+		t.Errorf("cell(0, 1) = %q, %v", got, ok)
+	}
+	if p.row(1) != nil || p.cols(1) != 0 || p.id(2) != 3 {
+		t.Errorf("row(1) = %v, id(2) = %d", p.row(1), p.id(2))
+	}
+
+	// offsets out of order or past the rows are rejected
+	bad := &packedRows{off: append([]byte(nil), p.off...), rows: p.rows, ids: p.ids}
+	bad.off[8] = 200
+	if bad.valid() {
+		t.Error("valid accepted an offset past the rows")
+	}
+	bad.off[8], bad.off[16] = 9, 1
+	if bad.valid() {
+		t.Error("valid accepted offsets out of order")
+	}
+}
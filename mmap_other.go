@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package table
+
+import "os"
+
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, ErrMapUnsupported
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package table
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer maps a value to the form it is indexed and looked up by. Values
+// which normalize equally match each other, while the stored rows keep the
+// original strings.
+type Normalizer func(string) string
+
+// NFC is a normalizer which composes decomposed characters, so that "pie\u0300ce"
+// matches "pièce". It can be chained with FoldCase.
+func NFC(s string) string {
+	return norm.NFC.String(s)
+}
+
+// FoldCase is a normalizer which makes lookups case-insensitive, using the
+// simple Unicode case folding of strings.EqualFold
+func FoldCase(s string) string {
+	return strings.Map(foldRune, s)
+}
+
+// foldRune returns the smallest rune of the case folding orbit of r
+func foldRune(r rune) rune {
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// Chain returns a normalizer applying the normalizers in order
+func Chain(ns ...Normalizer) Normalizer {
+	return func(s string) string {
+		for _, n := range ns {
+			s = n(s)
+		}
+		return s
+	}
+}
+
+// key returns the indexed form of the value in column col
+func (b *bucket) key(col int, val string) string {
+	return normalize(b.norms, col, val)
+}
+
+// key returns the indexed form of the value in column col
+func (l *bucketLayout) key(col int, val string) string {
+	return normalize(l.norms, col, val)
+}
+
+func normalize(norms []Normalizer, col int, val string) string {
+	if col < 0 || col >= len(norms) || norms[col] == nil {
+		return val
+	}
+	return norms[col](val)
+}
+
+// normalized returns the columns which have a normalizer
+func normalized(norms []Normalizer) (cols []int) {
+	for c, n := range norms {
+		if n != nil {
+			cols = append(cols, c)
+		}
+	}
+	return
+}
+
+// SetNormalizer sets the normalizer of column col, nil removes it. Get, GetAll,
+// Count, Remove, QueryBy, QueryExpr and the range queries then match values
+// which normalize equally. Rows are returned with their original strings.
+// The index of every bucket is rebuilt. Normalizers are functions, so they
+// are neither logged nor saved: set them again before loading a snapshot.
+// A snapshot is re-indexed with the normalizers of the table unless it was
+// indexed by normalizers of the same names, see SetNamedNormalizer.
+func (t *Table) SetNormalizer(col int, n Normalizer) error {
+	return t.SetNamedNormalizer(col, "", n)
+}
+
+// SetNamedNormalizer is SetNormalizer with a name identifying the normalizer,
+// e.g. "nfc+fold", which snapshots store. Loading a snapshot keeps its index
+// only if every normalized column has a normalizer of the same name, so a name
+// must change whenever its normalizer maps any value differently. The empty
+// name is anonymous and always re-indexes.
+func (t *Table) SetNamedNormalizer(col int, name string, n Normalizer) error {
+	if col < 0 {
+		return &ColumnError{Col: col, Width: t.Width(), Err: ErrNegativeColumn}
+	}
+	if n == nil {
+		name = ""
+	}
+	// the buckets share the old slices, so they are copied
+	norms := make([]Normalizer, len(t.layout.norms))
+	copy(norms, t.layout.norms)
+	names := make([]string, len(t.layout.norms))
+	copy(names, t.layout.names)
+	for len(norms) <= col {
+		norms = append(norms, nil)
+		names = append(names, "")
+	}
+	norms[col], names[col] = n, name
+	for len(norms) > 0 && norms[len(norms)-1] == nil {
+		norms, names = norms[:len(norms)-1], names[:len(names)-1]
+	}
+	t.layout.norms, t.layout.names = norms, names
+	for i := range t.b {
+		t.b[i] = *t.layout.rebuild(&t.b[i])
+	}
+	return nil
+}
+
+// named returns the names of the normalizers of the normalized columns
+func (l *bucketLayout) named() (names []string) {
+	for c, n := range l.norms {
+		if n != nil {
+			names = append(names, l.names[c])
+		}
+	}
+	return
+}
+
+// indexedBy reports whether buckets indexed by normalizers on the columns
+// indexed, of the given names, match the normalizers of the layout
+func (l *bucketLayout) indexedBy(indexed []int, names []string) bool {
+	if !equalInts(indexed, normalized(l.norms)) {
+		return false
+	}
+	if len(indexed) == 0 {
+		return true
+	}
+	if len(names) != len(indexed) {
+		return false
+	}
+	for i, name := range l.named() {
+		if name == "" || name != names[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package table
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizer(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{
+		{"play", "pièce", "obra"},
+		{"coin", "Pièce", "moneda"},
+		{"room", "pie\u0300ce", "habitación"},
+		{"cup", "tasse", "taza"},
+	})
+	if err := tbl.SetNormalizer(1, Chain(NFC, FoldCase)); err != nil {
+		t.Fatalf("SetNormalizer: %v", err)
+	}
+	tbl.Insert([][]string{{"part", "PIÈCE", "pieza"}})
+
+	// 1. Every lookup matches all spellings and returns the stored strings
+	if got := tbl.Count(1, "PIE\u0300CE"); got != 4 {
+		t.Errorf("Count = %d; want 4", got)
+	}
+	var spellings []string
+	for _, row := range tbl.GetAll(1, "pièce") {
+		spellings = append(spellings, row[1])
+	}
+	if want := []string{"pièce", "Pièce", "pie\u0300ce", "PIÈCE"}; !reflect.DeepEqual(spellings, want) {
+		t.Errorf("GetAll spellings = %q; want %q", spellings, want)
+	}
+	if got := tbl.QueryBy(map[int]string{1: "Pie\u0300ce", 0: "room"}); !reflect.DeepEqual(got, [][]string{{"room", "pie\u0300ce", "habitación"}}) {
+		t.Errorf("QueryBy = %q", got)
+	}
+	if got := tbl.QueryExpr(And(Eq(1, "PIÈCE"), Not(In(1, "pièce")))); got != nil {
+		t.Errorf("QueryExpr of a contradiction = %q", got)
+	}
+	if got := tbl.QueryPrefix(1, "PIE\u0300"); len(got) != 4 {
+		t.Errorf("QueryPrefix = %q", got)
+	}
+	// other columns are still exact
+	if got := tbl.Get(0, "PLAY"); got != nil {
+		t.Errorf("Get on an exact column = %q", got)
+	}
+
+	// 2. The normalization survives snapshots and Compact
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	image := buf.Bytes()
+	loaded := &Table{}
+	loaded.SetNormalizer(1, Chain(NFC, FoldCase))
+	if _, err := loaded.ReadFrom(bytes.NewReader(image)); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := loaded.Count(1, "pièce"); got != 4 {
+		t.Errorf("Count after ReadFrom = %d; want 4", got)
+	}
+	// a table without the normalizer re-indexes the snapshot for exact lookups
+	exact := &Table{}
+	if _, err := exact.ReadFrom(bytes.NewReader(image)); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := exact.Count(1, "pièce"); got != 1 {
+		t.Errorf("exact Count after ReadFrom = %d; want 1", got)
+	}
+	loaded.Remove(1, "PIÈCE")
+	loaded.Compact()
+	if got := loaded.All(); !reflect.DeepEqual(got, [][]string{{"cup", "tasse", "taza"}}) {
+		t.Errorf("All after Remove and Compact = %q", got)
+	}
+
+	// 3. Removing the normalizer makes the column exact again
+	tbl.SetNormalizer(1, nil)
+	if got := tbl.Count(1, "pièce"); got != 1 {
+		t.Errorf("Count without normalizer = %d; want 1", got)
+	}
+}
+
+func TestNamedNormalizer(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{{"coin", "Pièce"}, {"room", "pie\u0300ce"}, {"cup", "tasse"}})
+	tbl.SetNamedNormalizer(1, "fold", FoldCase)
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	image := buf.Bytes()
+
+	// a normalizer of the same name keeps the stored index, another one
+	// re-indexes the snapshot, even on the same column
+	for _, c := range []struct {
+		name  string
+		n     Normalizer
+		get   string
+		count int
+	}{
+		{"fold", FoldCase, "PIÈCE", 1},
+		{"nfc", NFC, "pièce", 1},
+		{"", Chain(NFC, FoldCase), "PIÈCE", 2},
+	} {
+		loaded := &Table{}
+		loaded.SetNamedNormalizer(1, c.name, c.n)
+		if _, err := loaded.ReadFrom(bytes.NewReader(image)); err != nil {
+			t.Fatalf("ReadFrom: %v", err)
+		}
+		if got := loaded.Count(1, c.get); got != c.count {
+			t.Errorf("%q: Count(%q) = %d; want %d", c.name, c.get, got, c.count)
+		}
+		if got := loaded.Get(1, "pie\u0300ce"); got == nil {
+			t.Errorf("%q: Get of the stored spelling missed", c.name)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "named.tbl")
+	if err := tbl.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	m, err := OpenMappedNamed(path, []string{"", "nfc"}, nil, NFC)
+	if err != nil {
+		t.Fatalf("OpenMappedNamed: %v", err)
+	}
+	defer m.Close()
+	if got := m.Count(1, "pièce"); got != 1 {
+		t.Errorf("mapped Count(pièce) = %d; want 1", got)
+	}
+}
+
+func TestNormalizerRecover(t *testing.T) {
+	dir := t.TempDir()
+	logPath, snapPath := filepath.Join(dir, "table.wal"), filepath.Join(dir, "table.tbl")
+	w, err := OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl, err := RecoverNamed(snapPath, w, []string{"fold"}, FoldCase)
+	if err != nil {
+		t.Fatalf("RecoverNamed of empty state: %v", err)
+	}
+	tbl.Insert([][]string{{"Cup", "1"}, {"Key", "2"}, {"Bank", "3"}})
+	if err := tbl.Checkpoint(snapPath); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	tbl.Remove(0, "cup")
+	tbl.DeleteBy(map[int]string{0: "KEY"})
+	w.Close()
+
+	// the logged deletes match the same rows after a recovery, whether the
+	// stored index is kept or rebuilt
+	for _, recover := range []func(*WAL) (*Table, error){
+		func(w *WAL) (*Table, error) { return RecoverNamed(snapPath, w, []string{"fold"}, FoldCase) },
+		func(w *WAL) (*Table, error) { return Recover(snapPath, w, FoldCase) },
+	} {
+		w, err := OpenWAL(logPath, WALOptions{})
+		if err != nil {
+			t.Fatalf("OpenWAL: %v", err)
+		}
+		recovered, err := recover(w)
+		if err != nil {
+			t.Fatalf("Recover: %v", err)
+		}
+		if got := recovered.All(); !reflect.DeepEqual(got, [][]string{{"Bank", "3"}}) {
+			t.Errorf("recovered %q; want only Bank", got)
+		}
+		if got := recovered.Get(0, "BANK"); got == nil {
+			t.Errorf("recovered table does not look up with the normalizer")
+		}
+		w.Close()
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	for _, pair := range [][2]string{{"Straße", "STRAßE"}, {"ΣΊΣΥΦΟΣ", "σίσυφος"}, {"K", "K"}} {
+		if FoldCase(pair[0]) != FoldCase(pair[1]) {
+			t.Errorf("FoldCase(%q) != FoldCase(%q)", pair[0], pair[1])
+		}
+	}
+	if FoldCase("a") == FoldCase("b") {
+		t.Errorf("FoldCase folds different letters together")
+	}
+}
+
+func TestNewBucketParallelNormalized(t *testing.T) {
+	rnd := rand.New(rand.NewSource(15))
+	rows := make([][]string, 3000)
+	for y := range rows {
+		rows[y] = []string{randString(rnd, 1), randString(rnd, 1)}
+	}
+	norms := []Normalizer{nil, FoldCase}
+	serial := newBucketSerial(rows, norms)
+	parallel := newBucketParallel(rows, norms)
+	if !reflect.DeepEqual(serial.index, parallel.index) {
+		t.Fatalf("parallel normalized index differs from the serial one")
+	}
+	if got, want := serial.count(1, "A"), len(serial.getAll(1, "a")); got != want || got == 0 {
+		t.Fatalf("count(A) = %d, getAll(a) has %d rows", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package table
+
+import "sort"
+
+// opKind identifies the table mutation carried by an op
+type opKind byte
+
+const (
+	opInsert opKind = iota + 1
+	opInsertHoles
+	opRemove
+	opDeleteBy
+	opUpdateBy
+	opUpsert
+	opSchema
+	opRangeColumns
+	opDeleteByID
+	opBatch
+	opUniqueKeys
+)
+
+// op is a single table mutation with the arguments of the public call
+type op struct {
+	kind    opKind
+	rows    [][]string
+	col     int
+	val     string
+	filters map[int]string
+	set     map[int]string
+	cols    []int
+	ids     []RowID
+	batch   []op
+	keys    []UniqueKey
+}
+
+// clone copies the filters, the set and the row list of the op, which the
+// caller may reuse while the op is kept for a replay
+func (o op) clone() op {
+	o.filters = cloneFilters(o.filters)
+	o.set = cloneFilters(o.set)
+	if o.rows != nil {
+		o.rows = append([][]string(nil), o.rows...)
+	}
+	return o
+}
+
+// apply performs the mutation without logging it.
+// It returns the number of rows reported by UpdateBy and Upsert.
+func (b *Table) apply(o op) (n int) {
+	o = b.assign(o)
+	switch o.kind {
+	case opInsert:
+		b.insert(o.rows, o.ids)
+	case opInsertHoles:
+		b.insertHoles(o.rows, o.ids)
+	case opRemove:
+		b.remove(o.col, o.val)
+	case opDeleteBy:
+		b.deleteBy(o.filters)
+	case opUpdateBy:
+		n = b.updateBy(o.filters, o.set)
+	case opUpsert:
+		n = b.upsert(o.filters, o.rows, o.ids)
+	case opSchema:
+		b.setSchema(o.rows[0])
+	case opRangeColumns:
+		b.setRangeColumns(o.cols)
+	case opDeleteByID:
+		n = b.deleteByID(o.ids)
+	case opBatch:
+		for _, sub := range o.batch {
+			b.apply(sub)
+		}
+	case opUniqueKeys:
+		b.keys = o.keys
+	}
+	return
+}
+
+// exec records the mutation in the attached WAL, if any, and applies it.
+// It reports whether the mutation was applied.
+func (b *Table) exec(o op) (n int, ok bool) {
+	if b.wal != nil {
+		lsn, err := b.wal.append(o)
+		if err != nil {
+			return 0, false
+		}
+		b.lsn = lsn
+	}
+	n = b.apply(o)
+	b.autoCompact()
+	return n, true
+}
+
+func (s *snapshotWriter) op(o op) {
+	s.write([]byte{byte(o.kind)})
+	switch o.kind {
+	case opInsert, opInsertHoles:
+		s.rows(o.rows)
+		s.ids(o.ids)
+	case opRemove:
+		s.uvarint(uint64(o.col))
+		s.str(o.val)
+	case opDeleteBy:
+		s.filters(o.filters)
+	case opUpdateBy:
+		s.filters(o.filters)
+		s.filters(o.set)
+	case opUpsert:
+		s.filters(o.filters)
+		s.rows(o.rows)
+		s.ids(o.ids)
+	case opSchema:
+		s.rows(o.rows)
+	case opRangeColumns:
+		s.ints(o.cols)
+	case opDeleteByID:
+		s.ids(o.ids)
+	case opBatch:
+		s.uvarint(uint64(len(o.batch)))
+		for _, sub := range o.batch {
+			s.op(sub)
+		}
+	case opUniqueKeys:
+		s.keys(o.keys)
+	}
+}
+
+// filters writes a filter map in column order
+func (s *snapshotWriter) filters(q map[int]string) {
+	cols := make([]int, 0, len(q))
+	for c := range q {
+		cols = append(cols, c)
+	}
+	sort.Ints(cols)
+	s.uvarint(uint64(len(cols)))
+	for _, c := range cols {
+		s.uvarint(uint64(c))
+		s.str(q[c])
+	}
+}
+
+func (s *snapshotReader) op() (o op) {
+	kind := s.bytes(s.length(1))
+	if len(kind) == 0 {
+		return
+	}
+	o.kind = opKind(kind[0])
+	switch o.kind {
+	case opInsert, opInsertHoles:
+		o.rows = s.rows()
+		o.ids = s.idsOf(len(o.rows))
+	case opRemove:
+		o.col = int(s.uvarint())
+		o.val = s.str()
+	case opDeleteBy:
+		o.filters = s.filters()
+	case opUpdateBy:
+		o.filters = s.filters()
+		o.set = s.filters()
+	case opUpsert:
+		o.filters = s.filters()
+		o.rows = s.rows()
+		o.ids = s.idsOf(len(o.rows))
+	case opSchema:
+		if o.rows = s.rows(); len(o.rows) != 1 {
+			s.err = ErrSnapshotCorrupt
+		}
+	case opRangeColumns:
+		o.cols = s.ints()
+	case opDeleteByID:
+		o.ids = s.ids()
+	case opBatch:
+		n := s.length(s.uvarint())
+		o.batch = make([]op, 0, n)
+		for i := 0; i < n && s.err == nil; i++ {
+			sub := s.op()
+			// batches do not nest
+			if sub.kind == opBatch || sub.kind == 0 {
+				s.err = ErrSnapshotCorrupt
+			}
+			o.batch = append(o.batch, sub)
+		}
+	case opUniqueKeys:
+		o.keys = s.keys()
+	default:
+		s.err = ErrSnapshotCorrupt
+	}
+	return
+}
+
+func (s *snapshotReader) filters() map[int]string {
+	n := s.length(s.uvarint())
+	q := make(map[int]string, n)
+	for i := 0; i < n && s.err == nil; i++ {
+		c := int(s.uvarint())
+		q[c] = s.str()
+	}
+	return q
+}
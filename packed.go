@@ -0,0 +1,200 @@
+package table
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// packedRows are the rows and row IDs of a bucket as stored in a snapshot.
+// A mapped table reads them in place, so a row costs no memory until it is
+// loaded, and only the loaded row is decoded.
+type packedRows struct {
+	// off is the offset of every row in rows, 8 bytes little endian each
+	off []byte
+	// rows are the encoded rows, see snapshotWriter.row
+	rows []byte
+	// ids is the ID of every row, 8 bytes little endian each
+	ids []byte
+}
+
+func (p *packedRows) len() int {
+	return len(p.off) / 8
+}
+
+// span returns the encoding of the row at position y
+func (p *packedRows) span(y int) []byte {
+	start := binary.LittleEndian.Uint64(p.off[8*y:])
+	end := uint64(len(p.rows))
+	if 8*(y+1) < len(p.off) {
+		end = binary.LittleEndian.Uint64(p.off[8*(y+1):])
+	}
+	return p.rows[start:end:end]
+}
+
+// cols returns the number of columns of the row at position y, 0 for a hole
+func (p *packedRows) cols(y int) int {
+	v, _ := binary.Uvarint(p.span(y))
+	if v == 0 {
+		return 0
+	}
+	return int(v - 1)
+}
+
+// row decodes the row at position y. The strings point into the snapshot.
+func (p *packedRows) row(y int) []string {
+	b := p.span(y)
+	v, n := binary.Uvarint(b)
+	if v == 0 {
+		return nil
+	}
+	row := make([]string, v-1)
+	for x := range row {
+		row[x], b = unpackString(b[n:])
+		n = 0
+	}
+	return row
+}
+
+// cell returns the value in column col of the row at position y, without
+// decoding the other values, and false if the row is too short
+func (p *packedRows) cell(y, col int) (string, bool) {
+	b := p.span(y)
+	v, n := binary.Uvarint(b)
+	if col < 0 || uint64(col)+1 >= v {
+		return "", false
+	}
+	b = b[n:]
+	for x := 0; x < col; x++ {
+		size, n := binary.Uvarint(b)
+		b = b[n+int(size):]
+	}
+	s, _ := unpackString(b)
+	return s, true
+}
+
+func (p *packedRows) id(y int) RowID {
+	return RowID(binary.LittleEndian.Uint64(p.ids[8*y:]))
+}
+
+// valid reports whether the offsets and every encoded row are well formed
+func (p *packedRows) valid() bool {
+	var prev uint64
+	for y := 0; y < p.len(); y++ {
+		start := binary.LittleEndian.Uint64(p.off[8*y:])
+		if start < prev || start > uint64(len(p.rows)) {
+			return false
+		}
+		prev = start
+	}
+	if p.len() > 0 && binary.LittleEndian.Uint64(p.off) != 0 {
+		return false
+	}
+	for y := 0; y < p.len(); y++ {
+		b := p.span(y)
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return false
+		}
+		b = b[n:]
+		for x := uint64(1); x < v; x++ {
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return false
+			}
+			b = b[n+int(size):]
+		}
+		if len(b) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// unpackString returns the length prefixed string at the start of b and the
+// bytes after it. The string points into b.
+func unpackString(b []byte) (string, []byte) {
+	size, n := binary.Uvarint(b)
+	p := b[n : n+int(size)]
+	if len(p) == 0 {
+		return "", b[n:]
+	}
+	return *(*string)(unsafe.Pointer(&p)), b[n+int(size):]
+}
+
+// rowSize returns the size of the encoding of row
+func rowSize(row []string) uint64 {
+	if row == nil {
+		return uvarintSize(0)
+	}
+	n := uvarintSize(uint64(len(row)) + 1)
+	for _, v := range row {
+		n += uvarintSize(uint64(len(v))) + uint64(len(v))
+	}
+	return n
+}
+
+func uvarintSize(v uint64) uint64 {
+	n := uint64(1)
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// size returns the number of rows of the bucket, including the holes
+func (b *bucket) size() int {
+	if b.packed != nil {
+		return b.packed.len()
+	}
+	return len(b.data)
+}
+
+// at returns the row at position y, even if it was deleted
+func (b *bucket) at(y int) []string {
+	if b.packed != nil {
+		return b.packed.row(y)
+	}
+	return b.data[y]
+}
+
+// cell returns the value in column col of the row at position y, even if it
+// was deleted, and false if the row is too short
+func (b *bucket) cell(y, col int) (string, bool) {
+	if b.packed != nil {
+		return b.packed.cell(y, col)
+	}
+	if row := b.data[y]; col < len(row) {
+		return row[col], true
+	}
+	return "", false
+}
+
+// value returns the value in column col of the row at position y, which is
+// known to have the column
+func (b *bucket) value(y, col int) string {
+	v, _ := b.cell(y, col)
+	return v
+}
+
+// rows returns all rows of the bucket, even the deleted ones
+func (b *bucket) rows() [][]string {
+	if b.packed == nil {
+		return b.data
+	}
+	rows := make([][]string, b.packed.len())
+	for y := range rows {
+		rows[y] = b.packed.row(y)
+	}
+	return rows
+}
+
+// unpack decodes the packed rows and IDs of the bucket onto the heap. The
+// strings keep pointing into the snapshot.
+func (b *bucket) unpack() {
+	if b.packed == nil {
+		return
+	}
+	ids := b.idList()
+	b.data, b.packed = b.rows(), nil
+	b.setIDs(ids)
+}
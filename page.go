@@ -0,0 +1,142 @@
+package table
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+var (
+	// ErrStaleCursor is returned for a page cursor whose rows were moved by a
+	// compaction or replaced by a load since it was issued. The listing has to
+	// start over.
+	ErrStaleCursor = errors.New("table: cursor invalidated by compaction")
+	// ErrBadCursor is returned for a page cursor which was not issued by the table
+	ErrBadCursor = errors.New("table: malformed cursor")
+)
+
+// cursor is the position of the next row of a page listing.
+// Inserts only append buckets and deletes only leave holes, so the position
+// stays valid until a compaction moves the rows, which bumps the generation,
+// or a load replaces them, which changes the epoch.
+type cursor struct {
+	epoch  uint64
+	gen    uint64
+	bucket int
+	pos    int
+}
+
+// newEpoch returns a random epoch for a loaded table
+func newEpoch() uint64 {
+	var buf [8]byte
+	rand.Read(buf[:])
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+func (c cursor) String() string {
+	var buf [4 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], c.epoch)
+	n += binary.PutUvarint(buf[n:], c.gen)
+	n += binary.PutUvarint(buf[n:], uint64(c.bucket))
+	n += binary.PutUvarint(buf[n:], uint64(c.pos))
+	return base64.RawURLEncoding.EncodeToString(buf[:n])
+}
+
+// parseCursor decodes a cursor of the table, the empty cursor is the first page
+func (t *Table) parseCursor(s string) (c cursor, err error) {
+	if s == "" {
+		return cursor{epoch: t.epoch, gen: t.gen}, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrBadCursor
+	}
+	var fields [4]uint64
+	for i := range fields {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return c, ErrBadCursor
+		}
+		fields[i], buf = v, buf[n:]
+	}
+	if len(buf) != 0 {
+		return c, ErrBadCursor
+	}
+	c = cursor{epoch: fields[0], gen: fields[1], bucket: int(fields[2]), pos: int(fields[3])}
+	if c.epoch != t.epoch || c.gen != t.gen {
+		return c, ErrStaleCursor
+	}
+	if fields[2] > uint64(len(t.b)) || fields[3] > uint64(1<<32) {
+		return c, ErrBadCursor
+	}
+	return c, nil
+}
+
+// page collects up to limit rows starting at the cursor and returns the cursor
+// of the next row. each yields the positions of the matching rows of a bucket
+// from a position on, in ascending order.
+func (t *Table) page(c cursor, limit int, each func(b *bucket, from int, yield func(idx int) bool)) (rows [][]string, next string) {
+	for i := c.bucket; i < len(t.b) && next == ""; i++ {
+		buck := &t.b[i]
+		from := 0
+		if i == c.bucket {
+			from = c.pos
+		}
+		each(buck, from, func(idx int) bool {
+			if limit > 0 && len(rows) == limit {
+				next = cursor{epoch: t.epoch, gen: t.gen, bucket: i, pos: idx}.String()
+				return false
+			}
+			rows = append(rows, buck.at(idx))
+			return true
+		})
+	}
+	return rows, next
+}
+
+// eachLive yields the positions of the rows which are not holes
+func (b *bucket) eachLive(from int, yield func(idx int) bool) {
+	for y := from; y < b.size(); y++ {
+		if !b.hole(y) && !yield(y) {
+			return
+		}
+	}
+}
+
+// AllPage returns up to limit rows of the table, skipping holes, starting at
+// cursor, and the cursor of the next page. The empty cursor starts at the
+// first row and an empty next cursor means there are no more rows. A limit
+// below 1 returns all remaining rows. Cursors survive inserts and deletes,
+// but fail with ErrStaleCursor after the table was compacted or loaded.
+func (t *Table) AllPage(cursor string, limit int) (rows [][]string, next string, err error) {
+	c, err := t.parseCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, next = t.page(c, limit, (*bucket).eachLive)
+	return rows, next, nil
+}
+
+// QueryByPage is AllPage for the rows matching every (col→val). It returns
+// ErrEmptyFilter or a ColumnError for invalid filters, like TryQueryBy.
+func (t *Table) QueryByPage(filters map[int]string, cursor string, limit int) (rows [][]string, next string, err error) {
+	if err := t.checkFilter(filters); err != nil {
+		return nil, "", err
+	}
+	c, err := t.parseCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, next = t.page(c, limit, func(b *bucket, from int, yield func(idx int) bool) {
+		pos := b.matchBy(filters)
+		sort.Ints(pos)
+		for _, idx := range pos[sort.SearchInts(pos, from):] {
+			if !yield(idx) {
+				return
+			}
+		}
+	})
+	return rows, next, nil
+}
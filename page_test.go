@@ -0,0 +1,97 @@
+package table
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestAllPage(t *testing.T) {
+	tbl := &Table{}
+	for i := 0; i < 5; i++ {
+		var rows [][]string
+		for j := 0; j < 7; j++ {
+			n := i*7 + j
+			rows = append(rows, []string{strconv.Itoa(n), strconv.Itoa(n % 3)})
+		}
+		tbl.Insert(rows)
+	}
+	tbl.Remove(0, "3")
+	tbl.Remove(0, "7")
+
+	// 1. Paging through everything returns All in order
+	for _, limit := range []int{1, 4, 7, 33, 100} {
+		var got [][]string
+		var cursor string
+		for pages := 0; ; pages++ {
+			rows, next, err := tbl.AllPage(cursor, limit)
+			if err != nil {
+				t.Fatalf("limit %d: AllPage: %v", limit, err)
+			}
+			if len(rows) > limit {
+				t.Fatalf("limit %d: page of %d rows", limit, len(rows))
+			}
+			got = append(got, rows...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if !reflect.DeepEqual(got, tbl.All()) {
+			t.Fatalf("limit %d: pages = %q; want %q", limit, got, tbl.All())
+		}
+	}
+	q := map[int]string{1: "0"}
+	var got [][]string
+	rows, next, err := tbl.QueryByPage(q, "", 5)
+	got = append(got, rows...)
+	if err != nil || next == "" || len(rows) != 5 {
+		t.Fatalf("QueryByPage = %q, %q, %v", rows, next, err)
+	}
+
+	// 2. The cursor survives inserts and deletes
+	tbl.Insert([][]string{{"99", "0"}})
+	tbl.Remove(0, "18")
+	for next != "" {
+		rows, next, err = tbl.QueryByPage(q, next, 5)
+		if err != nil {
+			t.Fatalf("QueryByPage after writes: %v", err)
+		}
+		got = append(got, rows...)
+	}
+	if want := tbl.QueryBy(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryByPage pages = %q; want %q", got, want)
+	}
+
+	// 3. Compact invalidates the cursor
+	_, next, _ = tbl.AllPage("", 3)
+	tbl.Compact()
+	if _, _, err := tbl.AllPage(next, 3); !errors.Is(err, ErrStaleCursor) {
+		t.Errorf("AllPage after Compact err = %v; want ErrStaleCursor", err)
+	}
+
+	// 4. A load invalidates the cursor, even if the generations happen to match
+	_, next, _ = tbl.AllPage("", 3)
+	var buf bytes.Buffer
+	tbl.WriteTo(&buf)
+	image := buf.Bytes()
+	for _, loaded := range []*Table{tbl, {gen: tbl.gen - 1}} {
+		if _, err := loaded.ReadFrom(bytes.NewReader(image)); err != nil {
+			t.Fatalf("ReadFrom: %v", err)
+		}
+		if _, _, err := loaded.AllPage(next, 3); !errors.Is(err, ErrStaleCursor) {
+			t.Errorf("AllPage after ReadFrom err = %v; want ErrStaleCursor", err)
+		}
+	}
+	if _, _, err := tbl.AllPage("not a cursor!", 3); !errors.Is(err, ErrBadCursor) {
+		t.Errorf("AllPage(garbage) err = %v; want ErrBadCursor", err)
+	}
+	if _, _, err := tbl.QueryByPage(nil, "", 3); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("QueryByPage(nil) err = %v; want ErrEmptyFilter", err)
+	}
+	if rows, next, err := tbl.AllPage("", 0); err != nil || next != "" || len(rows) != len(tbl.All()) {
+		t.Errorf("AllPage without limit = %d rows, %q, %v", len(rows), next, err)
+	}
+}
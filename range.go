@@ -0,0 +1,167 @@
+package table
+
+import (
+	"sort"
+	"sync"
+)
+
+// SetRangeColumns makes every bucket keep the positions of its rows sorted by
+// each of the given columns, so QueryRange and QueryPrefix on them binary
+// search instead of scanning. The sorted positions cost 4 bytes per row and
+// column. They are built by the first range query of every bucket, so loading
+// and mapping snapshots stay cheap. No columns disables it. If the attached
+// WAL rejects the change, its error is returned.
+func (t *Table) SetRangeColumns(cols ...int) error {
+	o, err := t.rangeColumnsOp(cols)
+	if err != nil {
+		return err
+	}
+	t.exec(o)
+	return t.walErr()
+}
+
+// rangeColumnsOp validates the range columns and returns the op setting them
+func (t *Table) rangeColumnsOp(cols []int) (op, error) {
+	for _, c := range cols {
+		if c < 0 {
+			return op{}, &ColumnError{Col: c, Width: t.Width(), Err: ErrNegativeColumn}
+		}
+	}
+	return op{kind: opRangeColumns, cols: append([]int{}, cols...)}, nil
+}
+
+func (t *Table) setRangeColumns(cols []int) {
+	t.layout.ranged = cols
+	for i := range t.b {
+		t.b[i].sortColumns(cols)
+	}
+}
+
+// RangeColumns returns the columns with sorted positions
+func (t *Table) RangeColumns() []int {
+	return append([]int(nil), t.layout.ranged...)
+}
+
+// sortedColumns are the positions of the rows of a bucket sorted by the range
+// columns. The rows of a bucket never change, so copies of the bucket share
+// them, and concurrent readers build them once.
+type sortedColumns struct {
+	once sync.Once
+	cols []int
+	pos  map[int][]uint32
+}
+
+// sortColumns makes the bucket sort its rows by each of the columns on the
+// first range query
+func (b *bucket) sortColumns(cols []int) {
+	b.sorted = nil
+	if len(cols) > 0 {
+		b.sorted = &sortedColumns{cols: cols}
+	}
+}
+
+// sortedBy returns the positions of the rows sorted by column col, building
+// the positions of all range columns first if needed. Rows too short for a
+// column are left out of its positions.
+func (b *bucket) sortedBy(col int) ([]uint32, bool) {
+	s := b.sorted
+	if s == nil {
+		return nil, false
+	}
+	s.once.Do(func() {
+		s.pos = make(map[int][]uint32, len(s.cols))
+		for _, c := range s.cols {
+			pos := make([]uint32, 0, b.size())
+			for y := 0; y < b.size(); y++ {
+				if c < b.cols(y) {
+					pos = append(pos, uint32(y))
+				}
+			}
+			sort.SliceStable(pos, func(i, j int) bool {
+				return b.key(c, b.value(int(pos[i]), c)) < b.key(c, b.value(int(pos[j]), c))
+			})
+			s.pos[c] = pos
+		}
+	})
+	pos, ok := s.pos[col]
+	return pos, ok
+}
+
+// valueRange is the half-open range lo <= value < hi, unbounded above if open
+type valueRange struct {
+	lo, hi string
+	open   bool
+}
+
+func (r valueRange) below(v string) bool {
+	return r.open || v < r.hi
+}
+
+// prefixRange returns the range of the values starting with prefix
+func prefixRange(prefix string) valueRange {
+	hi := []byte(prefix)
+	for len(hi) > 0 && hi[len(hi)-1] == 0xff {
+		hi = hi[:len(hi)-1]
+	}
+	if len(hi) == 0 {
+		return valueRange{lo: prefix, open: true}
+	}
+	hi[len(hi)-1]++
+	return valueRange{lo: prefix, hi: string(hi)}
+}
+
+// getRange appends the live rows whose value in column col is in r to data,
+// in value order if the column is sorted
+func (b *bucket) getRange(col int, r valueRange, data [][]string) [][]string {
+	if pos, ok := b.sortedBy(col); ok {
+		// deleted rows keep their value, so the search sees every position
+		i := sort.Search(len(pos), func(i int) bool {
+			return b.key(col, b.value(int(pos[i]), col)) >= r.lo
+		})
+		for ; i < len(pos); i++ {
+			if !r.below(b.key(col, b.value(int(pos[i]), col))) {
+				break
+			}
+			if !b.dead.has(int(pos[i])) {
+				data = append(data, b.at(int(pos[i])))
+			}
+		}
+		return data
+	}
+	for y := 0; y < b.size(); y++ {
+		if row := b.row(y); col < len(row) {
+			if v := b.key(col, row[col]); v >= r.lo && r.below(v) {
+				data = append(data, row)
+			}
+		}
+	}
+	return data
+}
+
+// queryRange returns the live rows whose value in column col is in r, sorted by it
+func (t *Table) queryRange(col int, r valueRange) (data [][]string) {
+	if col < 0 {
+		return nil
+	}
+	for i := range t.b {
+		data = t.b[i].getRange(col, r, data)
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		return t.layout.key(col, data[i][col]) < t.layout.key(col, data[j][col])
+	})
+	return
+}
+
+// QueryRange loads all the rows with lo <= value < hi in column col, skipping
+// holes, in ascending order of the value. Normalized columns compare normalized values. Columns set by SetRangeColumns are
+// binary searched, other columns are scanned.
+func (t *Table) QueryRange(col int, lo, hi string) [][]string {
+	return t.queryRange(col, valueRange{lo: t.layout.key(col, lo), hi: t.layout.key(col, hi)})
+}
+
+// QueryPrefix loads all the rows whose value in column col starts with prefix,
+// skipping holes, in ascending order of the value. On a normalized column the
+// normalized value must start with the normalized prefix.
+func (t *Table) QueryPrefix(col int, prefix string) [][]string {
+	return t.queryRange(col, prefixRange(t.layout.key(col, prefix)))
+}
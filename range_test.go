@@ -0,0 +1,174 @@
+package table
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestQueryRange(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{
+		{"apple", "3"}, {"apricot", "1"}, {"banana", "2"}, {"cherry", "5"},
+	})
+	tbl.Insert([][]string{{"avocado", "4"}, {"ap"}, {"b\xff", "6"}, {"\xff\xff", "7"}})
+	for _, sorted := range []bool{false, true} {
+		if sorted {
+			if err := tbl.SetRangeColumns(0, 1); err != nil {
+				t.Fatalf("SetRangeColumns: %v", err)
+			}
+		}
+		want := [][]string{{"apple", "3"}, {"apricot", "1"}, {"avocado", "4"}}
+		if got := tbl.QueryRange(0, "apple", "b"); !reflect.DeepEqual(got, want) {
+			t.Errorf("sorted %v: QueryRange(apple, b) = %q; want %q", sorted, got, want)
+		}
+		want = [][]string{{"ap"}, {"apple", "3"}, {"apricot", "1"}}
+		if got := tbl.QueryPrefix(0, "ap"); !reflect.DeepEqual(got, want) {
+			t.Errorf("sorted %v: QueryPrefix(ap) = %q; want %q", sorted, got, want)
+		}
+		want = [][]string{{"b\xff", "6"}}
+		if got := tbl.QueryPrefix(0, "b\xff"); !reflect.DeepEqual(got, want) {
+			t.Errorf("sorted %v: QueryPrefix(b\\xff) = %q; want %q", sorted, got, want)
+		}
+		want = [][]string{{"\xff\xff", "7"}}
+		if got := tbl.QueryPrefix(0, "\xff"); !reflect.DeepEqual(got, want) {
+			t.Errorf("sorted %v: QueryPrefix(\\xff) = %q; want %q", sorted, got, want)
+		}
+		// short rows are not in the range of a column they lack
+		if got := tbl.QueryRange(1, "", "3"); len(got) != 2 {
+			t.Errorf("sorted %v: QueryRange(1, , 3) = %q", sorted, got)
+		}
+		if got := tbl.QueryRange(0, "z", "a"); got != nil {
+			t.Errorf("sorted %v: empty range = %q", sorted, got)
+		}
+	}
+
+	// Removed rows disappear, the sorted positions survive Compact and snapshots
+	tbl.Remove(0, "apricot")
+	want := [][]string{{"apple", "3"}, {"avocado", "4"}}
+	if got := tbl.QueryRange(0, "apple", "b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryRange after Remove = %q; want %q", got, want)
+	}
+	tbl.Compact()
+	if got := tbl.QueryRange(0, "apple", "b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryRange after Compact = %q; want %q", got, want)
+	}
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := &Table{}
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := loaded.RangeColumns(); !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("loaded range columns = %v", got)
+	}
+	// the sorted positions are built by the first range query, not by the load
+	if s := loaded.b[0].sorted; s == nil || s.pos != nil {
+		t.Errorf("loaded bucket sorted positions = %+v; want unbuilt", s)
+	}
+	if got := loaded.QueryRange(0, "apple", "b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryRange after ReadFrom = %q; want %q", got, want)
+	}
+	if _, ok := loaded.b[0].sorted.pos[0]; !ok {
+		t.Errorf("loaded bucket has no sorted positions after QueryRange")
+	}
+	if err := tbl.SetRangeColumns(-1); err == nil {
+		t.Errorf("SetRangeColumns(-1) succeeded")
+	}
+}
+
+func TestQueryRangeFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(14))
+	sorted, scanned := &Table{}, &Table{}
+	sorted.SetRangeColumns(0, 2)
+	var all [][]string
+	for i := 0; i < 20; i++ {
+		var rows [][]string
+		for j := 0; j < 50; j++ {
+			rows = append(rows, randomRow(rnd, 4, 2))
+		}
+		all = append(all, rows...)
+		sorted.Insert(rows)
+		scanned.Insert(rows)
+		if i%5 == 4 {
+			val := all[rnd.Intn(len(all))][0]
+			sorted.Remove(0, val)
+			scanned.Remove(0, val)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		col := rnd.Intn(4)
+		lo, hi := randString(rnd, rnd.Intn(3)), randString(rnd, rnd.Intn(3))
+		var want [][]string
+		for _, row := range scanned.All() {
+			if col < len(row) && row[col] >= lo && row[col] < hi {
+				want = append(want, row)
+			}
+		}
+		got := sorted.QueryRange(col, lo, hi)
+		if other := scanned.QueryRange(col, lo, hi); !reflect.DeepEqual(got, other) {
+			t.Fatalf("QueryRange(%d, %q, %q) sorted %q, scanned %q", col, lo, hi, got, other)
+		}
+		for j := 1; j < len(got); j++ {
+			if got[j-1][col] > got[j][col] {
+				t.Fatalf("QueryRange(%d, %q, %q) out of order: %q", col, lo, hi, got)
+			}
+		}
+		sortRows(got)
+		sortRows(want)
+		if !equalRows(got, want) {
+			t.Fatalf("QueryRange(%d, %q, %q) = %q; want %q", col, lo, hi, got, want)
+		}
+	}
+}
+
+func TestQueryRangeConcurrentBuild(t *testing.T) {
+	tbl := &Table{}
+	tbl.SetRangeColumns(0)
+	for i := 0; i < 4; i++ {
+		tbl.Insert([][]string{{"b" + strconv.Itoa(i)}, {"a" + strconv.Itoa(i)}})
+	}
+	view := tbl.Snapshot()
+
+	// readers of a view build the sorted positions of its buckets once
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := view.QueryPrefix(0, "a"); len(got) != 4 {
+				t.Errorf("QueryPrefix(a) = %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRangeColumnsWALClosed(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "table.wal"), WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl := &Table{}
+	tbl.AttachWAL(w)
+	w.Close()
+
+	if err := tbl.SetRangeColumns(0); err != ErrWALClosed {
+		t.Errorf("SetRangeColumns = %v; want ErrWALClosed", err)
+	}
+	st := NewSyncTable(tbl)
+	if err := st.SetRangeColumns(0); err != ErrWALClosed {
+		t.Errorf("SyncTable.SetRangeColumns = %v; want ErrWALClosed", err)
+	}
+	st.Read(func(tbl *Table) {
+		if got := tbl.RangeColumns(); len(got) != 0 {
+			t.Errorf("RangeColumns = %v after rejected changes", got)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package table
+
+// The iterators below have the shape of iter.Seq[[]string], so on Go 1.23 and
+// later they can be ranged over directly:
+//
+//	for row := range t.Scan() {
+//		...
+//	}
+//
+// They walk the buckets in place without materializing the result, and stop
+// as soon as yield returns false. The table must not be modified while an
+// iterator runs.
+
+// Scan returns an iterator over all rows of the table, skipping the deletion holes
+func (t *Table) Scan() func(yield func(row []string) bool) {
+	return func(yield func(row []string) bool) {
+		for i := range t.b {
+			if !t.b[i].scan(false, yield) {
+				return
+			}
+		}
+	}
+}
+
+// ScanHoles returns an iterator over all rows of the table, including the deletion holes
+func (t *Table) ScanHoles() func(yield func(row []string) bool) {
+	return func(yield func(row []string) bool) {
+		for i := range t.b {
+			if !t.b[i].scan(true, yield) {
+				return
+			}
+		}
+	}
+}
+
+// ScanBy returns an iterator over the rows matching every (col→val), skipping
+// any holes. Panics if filters is nil or empty.
+func (t *Table) ScanBy(filters map[int]string) func(yield func(row []string) bool) {
+	mustFilter("ScanBy", filters)
+	return func(yield func(row []string) bool) {
+		for i := range t.b {
+			buck := &t.b[i]
+			for _, idx := range buck.matchBy(filters) {
+				if !yield(buck.at(idx)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// scan calls yield for the rows of the bucket, with or without the holes,
+// and reports whether yield asked for more rows
+func (b *bucket) scan(holes bool, yield func(row []string) bool) bool {
+	for y := 0; y < b.size(); y++ {
+		row := b.row(y)
+		if !holes && len(row) == 0 {
+			continue
+		}
+		if !yield(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// countLive returns the number of rows which are not holes
+func (t *Table) countLive() (n int) {
+	for i := range t.b {
+		n += t.b[i].size() - t.b[i].holes
+	}
+	return
+}
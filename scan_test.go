@@ -0,0 +1,66 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+// collect gathers the rows of an iterator, stopping after limit rows if limit > 0
+func collect(seq func(yield func(row []string) bool), limit int) (rows [][]string) {
+	seq(func(row []string) bool {
+		rows = append(rows, row)
+		return limit <= 0 || len(rows) < limit
+	})
+	return
+}
+
+func TestScan(t *testing.T) {
+	tbl := snapshotSample()
+	tbl.Insert([][]string{{"cup", "bol", "cuenco"}})
+
+	// 1. The iterators yield what the slice methods return
+	if got, want := collect(tbl.Scan(), 0), tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan = %q; want %q", got, want)
+	}
+	if got, want := collect(tbl.ScanHoles(), 0), tbl.AllHoles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanHoles = %q; want %q", got, want)
+	}
+	q := map[int]string{0: "cup"}
+	if got, want := collect(tbl.ScanBy(q), 0), tbl.QueryBy(q); !reflect.DeepEqual(got, want) || len(got) != 3 {
+		t.Errorf("ScanBy = %q; want %q", got, want)
+	}
+
+	// 2. Stopping early, also across buckets
+	for _, limit := range []int{1, 2, 3, 7} {
+		if got := collect(tbl.Scan(), limit); !reflect.DeepEqual(got, tbl.All()[:limit]) {
+			t.Errorf("Scan stopped after %d = %q", limit, got)
+		}
+	}
+	if got := collect(tbl.ScanBy(q), 2); len(got) != 2 {
+		t.Errorf("ScanBy stopped after 2 = %q", got)
+	}
+
+	// 3. SyncTable releases its lock when the iteration stops
+	st := &SyncTable{}
+	st.Insert(tbl.All())
+	if got := collect(st.ScanBy(q), 1); len(got) != 1 {
+		t.Errorf("SyncTable.ScanBy stopped after 1 = %q", got)
+	}
+	st.Remove(0, "cup")
+	if got := collect(st.Scan(), 0); len(got) != len(tbl.All())-3 {
+		t.Errorf("SyncTable.Scan after Remove = %q", got)
+	}
+
+	// 4. Compact drops the holes
+	tbl.Compact()
+	if got, want := tbl.AllHoles(), tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AllHoles after Compact = %q; want %q", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("ScanBy(nil) did not panic")
+		}
+	}()
+	tbl.ScanBy(nil)
+}
@@ -0,0 +1,158 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	// ErrUnknownColumn is returned for a column name which is not in the schema
+	ErrUnknownColumn = errors.New("table: unknown column")
+	// ErrNoSchema is returned for named column queries on a table without a schema
+	ErrNoSchema = errors.New("table: table has no schema")
+	// ErrDuplicateColumn is returned for a schema which names two columns the same
+	ErrDuplicateColumn = errors.New("table: duplicate column name")
+)
+
+// Schema names the columns of a table and fixes its width
+type Schema struct {
+	Columns []string
+}
+
+// Index returns the column with the given name
+func (s *Schema) Index(name string) (int, bool) {
+	if s == nil {
+		return 0, false
+	}
+	for i, c := range s.Columns {
+		if c == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// fits reports whether a non-empty row has the schema width, any row fits without schema
+func (s *Schema) fits(row []string) bool {
+	return s == nil || len(row) == len(s.Columns)
+}
+
+// RowError reports a row whose width does not match the schema
+type RowError struct {
+	// Row is the position of the row in the rows passed in
+	Row int
+	// Width is the width of the row
+	Width int
+	// Want is the width of the schema
+	Want int
+}
+
+func (e *RowError) Error() string {
+	return "table: row " + strconv.Itoa(e.Row) + " has " + strconv.Itoa(e.Width) +
+		" columns, schema has " + strconv.Itoa(e.Want)
+}
+
+// checkRows returns a RowError for the first non-empty row not fitting the schema
+func (s *Schema) checkRows(rows [][]string) error {
+	for i, row := range rows {
+		if len(row) > 0 && !s.fits(row) {
+			return &RowError{Row: i, Width: len(row), Want: len(s.Columns)}
+		}
+	}
+	return nil
+}
+
+// SetSchema attaches a schema to the table, nil removes it. From then on
+// Insert and InsertHoles reject rows of a different width. It fails with a
+// RowError if a live row of the table does not fit the schema, or with the
+// error of the attached WAL if it rejects the change.
+func (t *Table) SetSchema(s *Schema) error {
+	var cols []string
+	if s != nil {
+		seen := make(map[string]struct{}, len(s.Columns))
+		for _, c := range s.Columns {
+			if _, ok := seen[c]; ok {
+				return ErrDuplicateColumn
+			}
+			seen[c] = struct{}{}
+		}
+		if err := s.checkRows(t.All()); err != nil {
+			return err
+		}
+		cols = append([]string{}, s.Columns...)
+	}
+	t.exec(op{kind: opSchema, rows: [][]string{cols}})
+	return t.walErr()
+}
+
+func (t *Table) setSchema(cols []string) {
+	if cols == nil {
+		t.schema = nil
+		return
+	}
+	t.schema = &Schema{Columns: cols}
+}
+
+// Schema returns the schema of the table, or nil
+func (t *Table) Schema() *Schema {
+	return t.schema
+}
+
+// TryInsert inserts rows to the table ignoring holes and returns their IDs,
+// like Insert. If a row does not fit the schema, it inserts nothing and
+// returns a RowError. If a row would be rejected by a unique key, it inserts
+// nothing and returns a KeyError. If the attached WAL rejects the insert, its
+// error is returned.
+func (t *Table) TryInsert(data [][]string) ([]RowID, error) {
+	if err := t.schema.checkRows(data); err != nil {
+		return nil, err
+	}
+	if err := t.checkKeys(data); err != nil {
+		return nil, err
+	}
+	ids := t.Insert(data)
+	if err := t.walErr(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// resolve translates named filters to column filters
+func (t *Table) resolve(filters map[string]string) (map[int]string, error) {
+	if t.schema == nil {
+		return nil, ErrNoSchema
+	}
+	if len(filters) == 0 {
+		return nil, ErrEmptyFilter
+	}
+	out := make(map[int]string, len(filters))
+	for name, val := range filters {
+		c, ok := t.schema.Index(name)
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownColumn, name)
+		}
+		out[c] = val
+	}
+	return out, nil
+}
+
+// TryQueryByName finds all rows matching every (column name→val), skipping any holes
+func (t *Table) TryQueryByName(filters map[string]string) ([][]string, error) {
+	q, err := t.resolve(filters)
+	if err != nil {
+		return nil, err
+	}
+	return t.QueryBy(q), nil
+}
+
+// QueryByName finds all rows matching every (column name→val), skipping any holes.
+// Panics if filters is nil or empty, or names a column not in the schema.
+// Returns nil for no matches.
+func (t *Table) QueryByName(filters map[string]string) [][]string {
+	rows, err := t.TryQueryByName(filters)
+	if err != nil {
+		panic("QueryByName: " + err.Error())
+	}
+	return rows
+}
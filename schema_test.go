@@ -0,0 +1,110 @@
+package table
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{{"play", "pièce", "obra"}, {"cup", "tasse"}})
+
+	// 1. Duplicate names and non-fitting live rows are rejected
+	if err := tbl.SetSchema(&Schema{Columns: []string{"en", "en", "es"}}); !errors.Is(err, ErrDuplicateColumn) {
+		t.Errorf("SetSchema(duplicate) err = %v; want ErrDuplicateColumn", err)
+	}
+	var rowErr *RowError
+	if err := tbl.SetSchema(&Schema{Columns: []string{"en", "fr", "es"}}); !errors.As(err, &rowErr) || rowErr.Width != 2 {
+		t.Fatalf("SetSchema over a 2 column row err = %v; want RowError", err)
+	}
+	tbl.Remove(0, "cup")
+	if err := tbl.SetSchema(&Schema{Columns: []string{"en", "fr", "es"}}); err != nil {
+		t.Fatalf("SetSchema: %v", err)
+	}
+
+	// 2. Insert drops rows of the wrong width, TryInsert reports them
+	tbl.Insert([][]string{{"coin", "pièce", "moneda"}, {"bad"}, nil})
+	tbl.InsertHoles([][]string{{"room", "pièce", "habitación"}, {"bad", "row"}, nil})
+	if got := len(tbl.QueryBy(map[int]string{1: "pièce"})); got != 3 {
+		t.Errorf("QueryBy(pièce) has %d rows; want 3", got)
+	}
+	if tbl.Get(0, "bad") != nil {
+		t.Errorf("row of the wrong width was inserted")
+	}
+	if _, err := tbl.TryInsert([][]string{{"ok", "ok", "ok"}, {"bad", "row", "is", "wide"}}); !errors.As(err, &rowErr) || rowErr.Row != 1 || rowErr.Want != 3 {
+		t.Errorf("TryInsert err = %v; want RowError for row 1", err)
+	}
+	if tbl.Get(0, "ok") != nil {
+		t.Errorf("TryInsert inserted rows despite the error")
+	}
+
+	// 3. Named queries and schema width checks
+	want := [][]string{{"coin", "pièce", "moneda"}}
+	if got := tbl.QueryByName(map[string]string{"fr": "pièce", "es": "moneda"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryByName = %v; want %v", got, want)
+	}
+	if _, err := tbl.TryQueryByName(map[string]string{"de": "Stück"}); !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("TryQueryByName(de) err = %v; want ErrUnknownColumn", err)
+	}
+	if _, err := tbl.TryQueryBy(map[int]string{3: "x"}); !errors.Is(err, ErrColumnRange) {
+		t.Errorf("TryQueryBy(3) err = %v; want ErrColumnRange", err)
+	}
+	if _, err := (&Table{}).TryQueryByName(map[string]string{"en": "x"}); !errors.Is(err, ErrNoSchema) {
+		t.Errorf("TryQueryByName without schema err = %v; want ErrNoSchema", err)
+	}
+
+	// 4. The schema is part of the snapshot
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := &Table{}
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := loaded.Schema(); got == nil || !reflect.DeepEqual(got.Columns, []string{"en", "fr", "es"}) {
+		t.Errorf("loaded schema = %v", got)
+	}
+	if got := loaded.QueryByName(map[string]string{"en": "room"}); len(got) != 1 {
+		t.Errorf("QueryByName on loaded table = %v", got)
+	}
+
+	// 5. Removing the schema lifts the width check
+	if err := tbl.SetSchema(nil); err != nil {
+		t.Fatalf("SetSchema(nil): %v", err)
+	}
+	tbl.Insert([][]string{{"short"}})
+	if tbl.Get(0, "short") == nil {
+		t.Errorf("row rejected without a schema")
+	}
+}
+
+func TestSchemaWALClosed(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "table.wal"), WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl := &Table{}
+	tbl.AttachWAL(w)
+	w.Close()
+
+	if err := tbl.SetSchema(&Schema{Columns: []string{"word", "translation"}}); err != ErrWALClosed {
+		t.Errorf("SetSchema = %v; want ErrWALClosed", err)
+	}
+	if tbl.Schema() != nil {
+		t.Errorf("rejected schema was set")
+	}
+	if ids, err := tbl.TryInsert([][]string{{"cup", "tasse"}}); err != ErrWALClosed || ids != nil {
+		t.Errorf("TryInsert = %v, %v; want ErrWALClosed", ids, err)
+	}
+	st := NewSyncTable(tbl)
+	if ids, err := st.TryInsert([][]string{{"cup", "tasse"}}); err != ErrWALClosed || ids != nil {
+		t.Errorf("SyncTable.TryInsert = %v, %v; want ErrWALClosed", ids, err)
+	}
+	if got := st.All(); got != nil {
+		t.Errorf("rejected inserts were applied: %q", got)
+	}
+}
@@ -0,0 +1,570 @@
+package table
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// snapshotMagic identifies a table snapshot stream
+const snapshotMagic = "NLTB"
+
+// snapshotVersion is the snapshot format version written by WriteTo
+const snapshotVersion = 1
+
+// snapshotTrailer is the size of the checksum at the end of a snapshot
+const snapshotTrailer = 4
+
+var snapshotCrc = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrSnapshotMagic is returned when the input is not a table snapshot
+	ErrSnapshotMagic = errors.New("table: not a table snapshot")
+	// ErrSnapshotVersion is returned when the snapshot format version is not supported
+	ErrSnapshotVersion = errors.New("table: unsupported snapshot version")
+	// ErrSnapshotChecksum is returned when the snapshot is truncated or corrupt
+	ErrSnapshotChecksum = errors.New("table: snapshot checksum mismatch")
+	// ErrSnapshotCorrupt is returned when a checksummed snapshot cannot be decoded
+	ErrSnapshotCorrupt = errors.New("table: corrupt snapshot")
+)
+
+// snapshotWriter encodes the snapshot and keeps track of the running checksum
+type snapshotWriter struct {
+	w   *bufio.Writer
+	n   int64
+	crc uint32
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (s *snapshotWriter) write(p []byte) {
+	if s.err != nil {
+		return
+	}
+	var n int
+	n, s.err = s.w.Write(p)
+	s.n += int64(n)
+	s.crc = crc32.Update(s.crc, snapshotCrc, p[:n])
+}
+
+func (s *snapshotWriter) uvarint(v uint64) {
+	s.write(s.buf[:binary.PutUvarint(s.buf[:], v)])
+}
+
+func (s *snapshotWriter) varint(v int64) {
+	s.write(s.buf[:binary.PutVarint(s.buf[:], v)])
+}
+
+func (s *snapshotWriter) str(v string) {
+	s.uvarint(uint64(len(v)))
+	s.write([]byte(v))
+}
+
+// nilable writes length+1 of a nilable slice, 0 marks a nil slice
+func (s *snapshotWriter) nilable(isNil bool, length int) {
+	if isNil {
+		s.uvarint(0)
+	} else {
+		s.uvarint(uint64(length) + 1)
+	}
+}
+
+func (s *snapshotWriter) rows(rows [][]string) {
+	s.uvarint(uint64(len(rows)))
+	for _, row := range rows {
+		s.row(row)
+	}
+}
+
+// ids writes a list of row IDs as deltas, which are small for consecutive IDs
+func (s *snapshotWriter) ids(ids []RowID) {
+	s.uvarint(uint64(len(ids)))
+	var prev RowID
+	for _, id := range ids {
+		s.varint(int64(id - prev))
+		prev = id
+	}
+}
+
+// bitmap writes the words of a bitmap
+func (s *snapshotWriter) bitmap(m bitmap) {
+	s.uvarint(uint64(len(m)))
+	for _, w := range m {
+		s.uvarint(w)
+	}
+}
+
+// ints writes a list of non-negative ints
+func (s *snapshotWriter) ints(v []int) {
+	s.uvarint(uint64(len(v)))
+	for _, x := range v {
+		s.uvarint(uint64(x))
+	}
+}
+
+// strs writes a list of strings
+func (s *snapshotWriter) strs(v []string) {
+	s.uvarint(uint64(len(v)))
+	for _, x := range v {
+		s.str(x)
+	}
+}
+
+// keys writes unique keys
+func (s *snapshotWriter) keys(keys []UniqueKey) {
+	s.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		s.ints(k.Columns)
+		s.uvarint(uint64(k.OnConflict))
+	}
+}
+
+// row writes a row in the encoding of rows
+func (s *snapshotWriter) row(row []string) {
+	s.nilable(row == nil, len(row))
+	for _, cell := range row {
+		s.str(cell)
+	}
+}
+
+// fixed writes an unsigned integer in 8 bytes little endian
+func (s *snapshotWriter) fixed(v uint64) {
+	var p [8]byte
+	binary.LittleEndian.PutUint64(p[:], v)
+	s.write(p[:])
+}
+
+// packed writes the rows and IDs of a bucket as packedRows: the offsets of
+// the rows, the encoded rows and the IDs, so a mapped table can read them
+// in place
+func (s *snapshotWriter) packed(b *bucket) {
+	n := b.size()
+	s.uvarint(uint64(n))
+	if p := b.packed; p != nil {
+		s.write(p.off)
+		s.uvarint(uint64(len(p.rows)))
+		s.write(p.rows)
+		s.write(p.ids)
+		return
+	}
+	var off uint64
+	for _, row := range b.data {
+		s.fixed(off)
+		off += rowSize(row)
+	}
+	s.uvarint(off)
+	for _, row := range b.data {
+		s.row(row)
+	}
+	for y := 0; y < n; y++ {
+		s.fixed(uint64(b.id(y)))
+	}
+}
+
+func (s *snapshotWriter) bucket(b *bucket) {
+	s.uvarint(uint64(b.loglen))
+	s.packed(b)
+	s.uvarint(uint64(len(b.index)))
+	for _, level := range b.index {
+		s.uvarint(uint64(len(level)))
+		for _, filter := range level {
+			s.nilable(filter == nil, len(filter))
+			s.write(filter)
+		}
+	}
+}
+
+// snapshotReader decodes a snapshot which is already fully in memory.
+// Strings and filters point directly into buf instead of being copied.
+type snapshotReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (s *snapshotReader) varint() int64 {
+	if s.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(s.buf[s.off:])
+	if n <= 0 {
+		s.err = ErrSnapshotCorrupt
+		return 0
+	}
+	s.off += n
+	return v
+}
+
+func (s *snapshotReader) uvarint() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(s.buf[s.off:])
+	if n <= 0 {
+		s.err = ErrSnapshotCorrupt
+		return 0
+	}
+	s.off += n
+	return v
+}
+
+// length reads a length and makes sure it does not exceed the remaining input
+func (s *snapshotReader) length(v uint64) int {
+	if s.err != nil {
+		return 0
+	}
+	if v > uint64(len(s.buf)-s.off) {
+		s.err = ErrSnapshotCorrupt
+		return 0
+	}
+	return int(v)
+}
+
+func (s *snapshotReader) bytes(n int) []byte {
+	if s.err != nil {
+		return nil
+	}
+	p := s.buf[s.off : s.off+n : s.off+n]
+	s.off += n
+	return p
+}
+
+func (s *snapshotReader) str() string {
+	p := s.bytes(s.length(s.uvarint()))
+	if len(p) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&p))
+}
+
+// nilable reads a length written by snapshotWriter.nilable
+func (s *snapshotReader) nilable() (isNil bool, length int) {
+	v := s.uvarint()
+	if v == 0 {
+		return true, 0
+	}
+	return false, s.length(v - 1)
+}
+
+func (s *snapshotReader) rows() (data [][]string) {
+	rows := s.length(s.uvarint())
+	data = make([][]string, 0, rows)
+	for y := 0; y < rows && s.err == nil; y++ {
+		isNil, cols := s.nilable()
+		if isNil {
+			data = append(data, nil)
+			continue
+		}
+		row := make([]string, cols)
+		for x := range row {
+			row[x] = s.str()
+		}
+		data = append(data, row)
+	}
+	return
+}
+
+func (s *snapshotReader) ids() []RowID {
+	return s.idsOf(-1)
+}
+
+// idsOf reads a list of row IDs which must have n entries, any if n is negative
+func (s *snapshotReader) idsOf(n int) []RowID {
+	count := s.length(s.uvarint())
+	if n >= 0 && count != n {
+		s.err = ErrSnapshotCorrupt
+	}
+	if s.err != nil {
+		return nil
+	}
+	ids := make([]RowID, 0, count)
+	var prev RowID
+	for i := 0; i < count && s.err == nil; i++ {
+		prev += RowID(s.varint())
+		ids = append(ids, prev)
+	}
+	return ids
+}
+
+func (s *snapshotReader) ints() []int {
+	n := s.length(s.uvarint())
+	v := make([]int, 0, n)
+	for i := 0; i < n && s.err == nil; i++ {
+		x := s.uvarint()
+		if x > math.MaxInt32 {
+			s.err = ErrSnapshotCorrupt
+		}
+		v = append(v, int(x))
+	}
+	return v
+}
+
+// strs reads a list of strings
+func (s *snapshotReader) strs() []string {
+	n := s.length(s.uvarint())
+	v := make([]string, 0, n)
+	for i := 0; i < n && s.err == nil; i++ {
+		v = append(v, s.str())
+	}
+	return v
+}
+
+// keys reads unique keys
+func (s *snapshotReader) keys() (keys []UniqueKey) {
+	n := s.length(s.uvarint())
+	for i := 0; i < n && s.err == nil; i++ {
+		cols := s.ints()
+		conflict := Conflict(s.uvarint())
+		if len(cols) == 0 || conflict > Replace {
+			s.err = ErrSnapshotCorrupt
+		}
+		keys = append(keys, UniqueKey{Columns: cols, OnConflict: conflict})
+	}
+	return
+}
+
+// bitmap reads the bitmap of the deleted rows of a bucket of n rows, nil if empty
+func (s *snapshotReader) bitmap(n int) bitmap {
+	words := s.length(s.uvarint())
+	if words > (n+63)/64 {
+		s.err = ErrSnapshotCorrupt
+	}
+	if words == 0 || s.err != nil {
+		return nil
+	}
+	m := make(bitmap, (n+63)/64)
+	for i := 0; i < words && s.err == nil; i++ {
+		m[i] = s.uvarint()
+	}
+	return m
+}
+
+// packed reads the rows and IDs written by snapshotWriter.packed in place
+func (s *snapshotReader) packed() *packedRows {
+	n := s.length(s.uvarint())
+	p := &packedRows{off: s.fixed(n)}
+	p.rows = s.bytes(s.length(s.uvarint()))
+	p.ids = s.fixed(n)
+	if s.err != nil {
+		return nil
+	}
+	if !p.valid() {
+		s.err = ErrSnapshotCorrupt
+		return nil
+	}
+	return p
+}
+
+// fixed reads n unsigned integers of 8 bytes little endian
+func (s *snapshotReader) fixed(n int) []byte {
+	if s.err == nil && n > (len(s.buf)-s.off)/8 {
+		s.err = ErrSnapshotCorrupt
+	}
+	return s.bytes(8 * n)
+}
+
+func (s *snapshotReader) bucket() (b bucket) {
+	b.loglen = s.length(s.uvarint())
+	if p := s.packed(); p != nil {
+		b.pack(p)
+	}
+	levels := s.length(s.uvarint())
+	if levels > 0 {
+		b.index = make([][][]byte, 0, levels)
+	}
+	for j := 0; j < levels && s.err == nil; j++ {
+		cols := s.length(s.uvarint())
+		level := make([][]byte, cols)
+		for x := range level {
+			isNil, n := s.nilable()
+			if !isNil {
+				level[x] = s.bytes(n)
+			}
+		}
+		b.index = append(b.index, level)
+	}
+	return
+}
+
+// encodeSnapshot writes the header, all buckets and the trailing checksum
+func (b *Table) encodeSnapshot(w io.Writer) (int64, error) {
+	s := &snapshotWriter{w: bufio.NewWriter(w)}
+	s.write([]byte(snapshotMagic))
+	var version [4]byte
+	binary.LittleEndian.PutUint32(version[:], snapshotVersion)
+	s.write(version[:])
+	s.uvarint(b.lsn)
+	if b.schema != nil {
+		s.rows([][]string{b.schema.Columns})
+	} else {
+		s.rows(nil)
+	}
+	s.ints(b.layout.ranged)
+	s.ints(normalized(b.layout.norms))
+	s.strs(b.layout.named())
+	s.uvarint(uint64(b.next))
+	s.keys(b.keys)
+	s.uvarint(uint64(len(b.b)))
+	for i := range b.b {
+		s.bucket(&b.b[i])
+		s.bitmap(b.b[i].dead)
+	}
+	var sum [snapshotTrailer]byte
+	binary.LittleEndian.PutUint32(sum[:], s.crc)
+	s.write(sum[:])
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	return s.n, s.err
+}
+
+// decodeSnapshot verifies and decodes a complete snapshot image. Unless the
+// snapshot was indexed by normalizers of the same names on the same columns
+// as norms, its buckets are re-indexed with norms.
+func decodeSnapshot(buf []byte, norms []Normalizer, names []string) (*Table, error) {
+	const header = len(snapshotMagic) + 4
+	if len(buf) < len(snapshotMagic) || string(buf[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrSnapshotMagic
+	}
+	if len(buf) < header+snapshotTrailer {
+		return nil, ErrSnapshotChecksum
+	}
+	version := binary.LittleEndian.Uint32(buf[len(snapshotMagic):header])
+	if version != snapshotVersion {
+		return nil, ErrSnapshotVersion
+	}
+	body := buf[:len(buf)-snapshotTrailer]
+	if crc32.Checksum(body, snapshotCrc) != binary.LittleEndian.Uint32(buf[len(body):]) {
+		return nil, ErrSnapshotChecksum
+	}
+	s := &snapshotReader{buf: body, off: header}
+	t := &Table{epoch: newEpoch()}
+	t.lsn = s.uvarint()
+	if schema := s.rows(); len(schema) > 0 {
+		t.setSchema(schema[0])
+	}
+	if cols := s.ints(); len(cols) > 0 {
+		t.layout.ranged = cols
+	}
+	indexed := s.ints()
+	indexedBy := s.strs()
+	t.layout.norms, t.layout.names = norms, names
+	reindex := !t.layout.indexedBy(indexed, indexedBy)
+	t.next = RowID(s.uvarint())
+	t.keys = s.keys()
+	count := s.length(s.uvarint())
+	t.b = make([]bucket, 0, count)
+	for i := 0; i < count && s.err == nil; i++ {
+		buck := s.bucket()
+		buck.dead = s.bitmap(buck.size())
+		buck.holes = 0
+		for y := 0; y < buck.size(); y++ {
+			if buck.hole(y) {
+				buck.holes++
+			}
+		}
+		if reindex {
+			buck = *t.layout.rebuild(&buck)
+		} else {
+			// the sorted positions are not stored, the first range query or
+			// lookup by ID builds them
+			buck.norms = norms
+			buck.tally()
+			buck.sortColumns(t.layout.ranged)
+		}
+		t.b = append(t.b, buck)
+	}
+	if s.err == nil && s.off != len(body) {
+		s.err = ErrSnapshotCorrupt
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return t, nil
+}
+
+// restore replaces the persisted state of the table with the decoded snapshot
+func (b *Table) restore(from *Table) {
+	b.b = from.b
+	b.gen++
+	b.epoch = from.epoch
+	b.next = from.next
+	b.lsn = from.lsn
+	b.schema = from.schema
+	b.layout.ranged = from.layout.ranged
+	b.keys = from.keys
+	b.publish(Event{Kind: EventLoad})
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes a versioned, checksummed binary snapshot of the table to w.
+// The quaternary indices are stored as-is, so loading does not rebuild them.
+func (b *Table) WriteTo(w io.Writer) (n int64, err error) {
+	return b.encodeSnapshot(w)
+}
+
+// ReadFrom replaces the table contents with a snapshot read from r until EOF.
+// The loaded rows share one read buffer. On error the table is left unchanged.
+func (b *Table) ReadFrom(r io.Reader) (n int64, err error) {
+	buf, err := io.ReadAll(r)
+	n = int64(len(buf))
+	if err != nil {
+		return
+	}
+	loaded, err := decodeSnapshot(buf, b.layout.norms, b.layout.names)
+	if err != nil {
+		return
+	}
+	for i := range loaded.b {
+		loaded.b[i].unpack()
+	}
+	b.restore(loaded)
+	return
+}
+
+// Save atomically writes a snapshot of the table to the file at path
+func (b *Table) Save(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = b.WriteTo(f); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load replaces the table contents with the snapshot stored in the file at path
+func (b *Table) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = b.ReadFrom(f)
+	return err
+}
@@ -0,0 +1,139 @@
+package table
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func snapshotSample() *Table {
+	tbl := &Table{}
+	tbl.Insert([][]string{
+		{"play", "pièce", "obra"},
+		{"cup", "tasse", "taza"},
+		{"bank", "banque", "banco"},
+		{"coin", "pièce", "moneda"},
+		{"cup", "verre", "copa"},
+	})
+	tbl.InsertHoles([][]string{
+		{"earth", "terre", "tierra"},
+		nil,
+		{"", "", ""},
+	})
+	tbl.Insert([][]string{{"key", "clé", "llave"}})
+	tbl.Remove(0, "bank")
+	return tbl
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tbl := snapshotSample()
+
+	var buf bytes.Buffer
+	n, err := tbl.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+	}
+
+	loaded := &Table{}
+	if _, err := loaded.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+
+	// 1) Raw physical view, including the removed row and the inserted holes
+	if got, want := loaded.AllHoles(), tbl.AllHoles(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllHoles = %v; want %v", got, want)
+	}
+	// 2) Indices are loaded as-is and answer lookups
+	for _, q := range []struct {
+		col int
+		val string
+	}{{0, "cup"}, {1, "pièce"}, {2, "llave"}, {0, "bank"}, {1, "nothing"}} {
+		if got, want := loaded.GetAll(q.col, q.val), tbl.GetAll(q.col, q.val); !reflect.DeepEqual(got, want) {
+			t.Errorf("GetAll(%d, %q) = %v; want %v", q.col, q.val, got, want)
+		}
+		if got, want := loaded.Count(q.col, q.val), tbl.Count(q.col, q.val); got != want {
+			t.Errorf("Count(%d, %q) = %d; want %d", q.col, q.val, got, want)
+		}
+	}
+	if got := loaded.QueryBy(map[int]string{0: "cup", 2: "copa"}); !reflect.DeepEqual(got, [][]string{{"cup", "verre", "copa"}}) {
+		t.Errorf("QueryBy(cup+copa) = %v", got)
+	}
+}
+
+func TestSnapshotEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (&Table{}).WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := snapshotSample()
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if all := loaded.AllHoles(); len(all) != 0 {
+		t.Fatalf("loaded empty snapshot has rows: %v", all)
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := snapshotSample().WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	image := buf.Bytes()
+
+	check := func(name string, data []byte, want error) {
+		t.Helper()
+		tbl := &Table{}
+		tbl.Insert([][]string{{"untouched"}})
+		_, err := tbl.ReadFrom(bytes.NewReader(data))
+		if !errors.Is(err, want) {
+			t.Errorf("%s: err = %v; want %v", name, err, want)
+		}
+		if got := tbl.All(); !reflect.DeepEqual(got, [][]string{{"untouched"}}) {
+			t.Errorf("%s: failed load modified the table: %v", name, got)
+		}
+	}
+
+	// 1) Every truncation must fail cleanly
+	for i := 0; i < len(image); i++ {
+		tbl := &Table{}
+		if _, err := tbl.ReadFrom(bytes.NewReader(image[:i])); err == nil {
+			t.Fatalf("truncated snapshot of %d/%d bytes loaded without error", i, len(image))
+		}
+	}
+	check("truncated", image[:len(image)-7], ErrSnapshotChecksum)
+
+	// 2) Flipped payload bit
+	flipped := append([]byte(nil), image...)
+	flipped[len(flipped)/2] ^= 0x10
+	check("flipped", flipped, ErrSnapshotChecksum)
+
+	// 3) Wrong magic and future version
+	check("magic", append([]byte("XXXX"), image[4:]...), ErrSnapshotMagic)
+	future := append([]byte(nil), image...)
+	future[4] = 0xff
+	check("version", future, ErrSnapshotVersion)
+}
+
+func TestSnapshotSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dict.tbl")
+	tbl := snapshotSample()
+	if err := tbl.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded := &Table{}
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := loaded.All(), tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("All = %v; want %v", got, want)
+	}
+	if err := loaded.Load(path + ".missing"); err == nil {
+		t.Fatalf("Load of missing file succeeded")
+	}
+}
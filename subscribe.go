@@ -0,0 +1,203 @@
+package table
+
+import "sync"
+
+// EventKind identifies the mutation an Event reports
+type EventKind int
+
+const (
+	// EventInsert reports inserted rows, by Insert, InsertHoles, Upsert or a
+	// Batch, and the rows they replaced by a Replace unique key
+	EventInsert EventKind = iota + 1
+	// EventRemove reports the rows deleted by Remove
+	EventRemove
+	// EventDeleteBy reports the rows deleted by DeleteBy
+	EventDeleteBy
+	// EventDeleteByID reports the rows deleted by DeleteByID
+	EventDeleteByID
+	// EventUpdate reports the rows rewritten by UpdateBy
+	EventUpdate
+	// EventCompact reports that a compaction moved the rows. The rows are unchanged.
+	EventCompact
+	// EventLoad reports that ReadFrom or Load replaced all rows of the table
+	EventLoad
+)
+
+// Event is a change of the rows of a table, delivered to its subscribers
+type Event struct {
+	Kind EventKind
+	// Filters are the (col→val) of a Remove, DeleteBy or UpdateBy
+	Filters map[int]string
+	// Rows are the inserted or deleted rows, or the updated rows after the update.
+	// Holes are left out.
+	Rows [][]string
+	// Old are the updated rows before the update, by row
+	Old [][]string
+	// IDs are the IDs of the rows, by row
+	IDs []RowID
+	// Replaced are the rows an insert deleted by a Replace unique key, in
+	// delete order. They are not reported by a separate delete event.
+	Replaced [][]string
+	// ReplacedIDs are the IDs of the replaced rows, by row
+	ReplacedIDs []RowID
+	// Dropped is the number of events dropped before this one, see Drop
+	Dropped int
+}
+
+// Backpressure selects what happens when a subscriber falls behind the writers
+type Backpressure int
+
+const (
+	// Block makes the mutations wait until the subscriber took the event.
+	// The subscriber must not call the table then, or it may deadlock.
+	Block Backpressure = iota
+	// Drop discards the events which do not fit the buffer. The next delivered
+	// event counts them in Dropped, so the subscriber can rebuild its state.
+	Drop
+	// Buffer queues the events without a limit
+	Buffer
+)
+
+// SubscribeOptions configures a subscription
+type SubscribeOptions struct {
+	// Backpressure is the behaviour of a full buffer
+	Backpressure Backpressure
+	// Size is the number of events buffered by Block and Drop, default 64
+	Size int
+}
+
+// subscriber delivers the events of one subscription on its own goroutine, in order
+type subscriber struct {
+	fn   func(Event)
+	opts SubscribeOptions
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	queue   []Event
+	dropped int
+	closed  bool
+}
+
+// Subscribe calls fn with every following change of the table, in the order
+// the mutations were applied, on a goroutine of the subscription. The
+// returned cancel function ends the subscription, discarding the events not
+// delivered yet.
+func (t *Table) Subscribe(fn func(Event), opts SubscribeOptions) (cancel func()) {
+	s := t.subscribe(fn, opts)
+	return func() { t.unsubscribe(s) }
+}
+
+func (t *Table) subscribe(fn func(Event), opts SubscribeOptions) *subscriber {
+	if opts.Size <= 0 {
+		opts.Size = 64
+	}
+	s := &subscriber{fn: fn, opts: opts}
+	s.cond.L = &s.mu
+	go s.run()
+	t.subs = append(t.subs, s)
+	return s
+}
+
+func (t *Table) unsubscribe(s *subscriber) {
+	for i := range t.subs {
+		if t.subs[i] == s {
+			t.subs = append(t.subs[:i:i], t.subs[i+1:]...)
+			break
+		}
+	}
+	s.close()
+}
+
+// publish hands the event to every subscriber
+func (t *Table) publish(e Event) {
+	if t.replaying {
+		return
+	}
+	for _, s := range t.subs {
+		s.send(e)
+	}
+}
+
+func (s *subscriber) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.opts.Backpressure {
+	case Block:
+		for len(s.queue) >= s.opts.Size && !s.closed {
+			s.cond.Wait()
+		}
+	case Drop:
+		if len(s.queue) >= s.opts.Size {
+			s.dropped++
+			return
+		}
+	}
+	if s.closed {
+		return
+	}
+	e.Dropped, s.dropped = s.dropped, 0
+	s.queue = append(s.queue, e)
+	s.cond.Broadcast()
+}
+
+func (s *subscriber) run() {
+	s.mu.Lock()
+	for {
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		e := s.queue[0]
+		s.queue[0] = Event{}
+		s.queue = s.queue[1:]
+		// a blocked writer may go on
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		s.fn(e)
+		s.mu.Lock()
+	}
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.queue = nil
+	s.cond.Broadcast()
+}
+
+// hasSubscribers reports whether the events are published
+func (t *Table) hasSubscribers() bool {
+	return len(t.subs) > 0 && !t.replaying
+}
+
+// compacted publishes an EventCompact if the rows were moved since generation gen
+func (t *Table) compacted(gen uint64) {
+	if t.gen != gen {
+		t.publish(Event{Kind: EventCompact})
+	}
+}
+
+// publishInsert publishes the inserted rows which are not holes and the rows
+// they replaced
+func (t *Table) publishInsert(rows [][]string, ids []RowID, replaced *Tombstone) {
+	if !t.hasSubscribers() {
+		return
+	}
+	e := Event{Kind: EventInsert}
+	if replaced != nil {
+		e.Replaced, e.ReplacedIDs = replaced.Rows, replaced.IDs
+	}
+	for i, row := range rows {
+		if len(row) > 0 {
+			e.Rows = append(e.Rows, row)
+			e.IDs = append(e.IDs, ids[i])
+		}
+	}
+	if len(e.Rows) > 0 {
+		t.publish(e)
+	}
+}
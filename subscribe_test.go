@@ -0,0 +1,135 @@
+package table
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// subscribeEvents subscribes to the table and returns the channel of its events
+func subscribeEvents(t *testing.T, subscribe func(func(Event), SubscribeOptions) func(), opts SubscribeOptions) (<-chan Event, func()) {
+	t.Helper()
+	events := make(chan Event, 1000)
+	cancel := subscribe(func(e Event) { events <- e }, opts)
+	t.Cleanup(cancel)
+	return events, cancel
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event delivered")
+		return Event{}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{{"before"}})
+	events, _ := subscribeEvents(t, tbl.Subscribe, SubscribeOptions{Backpressure: Buffer})
+
+	ids := tbl.InsertHoles([][]string{{"cup", "tasse"}, nil, {"key", "clé"}, {"bank", "banque"}})
+	tbl.Remove(0, "cup")
+	tbl.Remove(0, "nothing")
+	tbl.UpdateBy(map[int]string{0: "key"}, map[int]string{1: "touche"})
+	tbl.DeleteByID(ids[3])
+	batch := tbl.Batch()
+	batch.DeleteBy(map[int]string{0: "key"})
+	batch.Insert([][]string{{"coin", "pièce"}})
+	batch.Commit()
+	tbl.Compact()
+
+	want := []Event{
+		{Kind: EventInsert, Rows: [][]string{{"cup", "tasse"}, {"key", "clé"}, {"bank", "banque"}}, IDs: []RowID{ids[0], ids[2], ids[3]}},
+		{Kind: EventRemove, Filters: map[int]string{0: "cup"}, Rows: [][]string{{"cup", "tasse"}}, IDs: []RowID{ids[0]}},
+		{Kind: EventUpdate, Filters: map[int]string{0: "key"}, Rows: [][]string{{"key", "touche"}}, Old: [][]string{{"key", "clé"}}, IDs: []RowID{ids[2]}},
+		{Kind: EventDeleteByID, Rows: [][]string{{"bank", "banque"}}, IDs: []RowID{ids[3]}},
+		{Kind: EventDeleteBy, Filters: map[int]string{0: "key"}, Rows: [][]string{{"key", "touche"}}, IDs: []RowID{ids[2]}},
+		{Kind: EventInsert, Rows: [][]string{{"coin", "pièce"}}, IDs: []RowID{ids[3] + 1}},
+		{Kind: EventCompact},
+	}
+	for i := range want {
+		if got := nextEvent(t, events); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("event %d = %+v; want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestSubscribeDrop(t *testing.T) {
+	tbl := &Table{}
+	gate := make(chan struct{})
+	taken := make(chan struct{}, 100)
+	events := make(chan Event, 100)
+	cancel := tbl.Subscribe(func(e Event) {
+		taken <- struct{}{}
+		<-gate
+		events <- e
+	}, SubscribeOptions{Backpressure: Drop, Size: 2})
+	defer cancel()
+
+	// one event is being delivered, two are buffered and the rest dropped
+	tbl.Insert([][]string{{"0"}})
+	<-taken
+	for i := 1; i < 10; i++ {
+		tbl.Insert([][]string{{strconv.Itoa(i)}})
+	}
+	close(gate)
+	for _, want := range []string{"0", "1", "2"} {
+		if e := nextEvent(t, events); e.Rows[0][0] != want || e.Dropped != 0 {
+			t.Errorf("event = %+v; want %s", e, want)
+		}
+	}
+	// the next delivered event counts the drops
+	tbl.Insert([][]string{{"last"}})
+	if e := nextEvent(t, events); e.Dropped != 7 || e.Rows[0][0] != "last" {
+		t.Errorf("event after drops = %+v; want Dropped 7", e)
+	}
+}
+
+func TestSyncTableSubscribeBlock(t *testing.T) {
+	var st SyncTable
+	events, _ := subscribeEvents(t, st.Subscribe, SubscribeOptions{Backpressure: Block, Size: 1})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			st.Insert([][]string{{strconv.Itoa(i)}})
+		}
+	}()
+	// the writers wait for the subscriber, which sees every insert in order
+	for i := 0; i < 100; i++ {
+		if e := nextEvent(t, events); e.Rows[0][0] != strconv.Itoa(i) || e.Dropped != 0 {
+			t.Fatalf("event %d = %+v", i, e)
+		}
+	}
+	<-done
+}
+
+func TestSyncTableSubscribeCancel(t *testing.T) {
+	var st SyncTable
+	gate := make(chan struct{})
+	cancel := st.Subscribe(func(Event) { <-gate }, SubscribeOptions{Backpressure: Block, Size: 1})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			st.Insert([][]string{{strconv.Itoa(i)}})
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// cancel releases the blocked writer
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writer still blocked after cancel")
+	}
+	close(gate)
+	if got := len(st.All()); got != 10 {
+		t.Errorf("All has %d rows; want 10", got)
+	}
+}
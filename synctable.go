@@ -0,0 +1,555 @@
+package table
+
+import (
+	"io"
+	"sync"
+)
+
+// SyncTable is a Table which is safe for concurrent use.
+// Read calls share a lock, write calls hold it exclusively. Remove and
+// DeleteBy are writers, because they punch holes into the buckets in place.
+// The zero value is an empty table ready to use.
+type SyncTable struct {
+	mu sync.RWMutex
+	t  Table
+
+	// compacting is closed when the running background compaction ends
+	compacting chan struct{}
+	// pending are the mutations to replay onto the background compaction
+	pending []op
+	// epoch is bumped to abandon a running background compaction
+	epoch uint64
+}
+
+// NewSyncTable wraps an existing table, which must not be used directly afterwards
+func NewSyncTable(t *Table) *SyncTable {
+	return &SyncTable{t: *t}
+}
+
+// Read calls fn with the table under the shared lock. fn must not mutate the table.
+func (s *SyncTable) Read(fn func(t *Table)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(&s.t)
+}
+
+// Write calls fn with the table under the exclusive lock.
+// It abandons a running background compaction.
+func (s *SyncTable) Write(fn func(t *Table)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandon()
+	fn(&s.t)
+}
+
+// exec applies a mutation under the exclusive lock and remembers it
+// for replay while a background compaction runs. It returns the number of
+// rows reported by the mutation and the IDs of the inserted rows.
+func (s *SyncTable) exec(o op) (int, []RowID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.execLocked(o)
+}
+
+// execLocked is exec for callers already holding the exclusive lock
+func (s *SyncTable) execLocked(o op) (int, []RowID) {
+	// the IDs are assigned once, so a replay inserts the rows with the same IDs
+	o = s.t.assign(o)
+	n, ok := s.t.exec(o)
+	if !ok {
+		return 0, nil
+	}
+	if s.compacting != nil {
+		// the caller may reuse the maps and the row list before the swap
+		s.pending = append(s.pending, o.clone())
+	}
+	return n, o.ids
+}
+
+// abandon makes a running background compaction discard its result
+func (s *SyncTable) abandon() {
+	s.epoch++
+	s.pending = nil
+	s.compacting = nil
+}
+
+// Count counts the number of occurences of string val in column col,
+// not counting the deleted rows
+func (s *SyncTable) Count(col int, val string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Count(col, val)
+}
+
+// CountHoles counts the number of occurences of string val in column col, including the deleted rows
+func (s *SyncTable) CountHoles(col int, val string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.CountHoles(col, val)
+}
+
+// GetAll loads all the rows which have string val in column col
+func (s *SyncTable) GetAll(col int, val string) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.GetAll(col, val)
+}
+
+// Get loads arbitrary single row which does have string val in column col
+func (s *SyncTable) Get(col int, val string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Get(col, val)
+}
+
+// QueryBy finds all rows matching every (col→val), skipping any holes.
+// Panics if filters is nil or empty.
+func (s *SyncTable) QueryBy(filters map[int]string) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryBy(filters)
+}
+
+// QueryByHoles finds all rows matching every (col→val), including any holes.
+// Panics if filters is nil or empty.
+func (s *SyncTable) QueryByHoles(filters map[int]string) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryByHoles(filters)
+}
+
+// QueryByName finds all rows matching every (column name→val), skipping any holes.
+// Panics if filters is nil or empty, or names a column not in the schema.
+func (s *SyncTable) QueryByName(filters map[string]string) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryByName(filters)
+}
+
+// TryQueryByName finds all rows matching every (column name→val), skipping any holes
+func (s *SyncTable) TryQueryByName(filters map[string]string) ([][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.TryQueryByName(filters)
+}
+
+// Schema returns the schema of the table, or nil
+func (s *SyncTable) Schema() *Schema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Schema()
+}
+
+// QueryExpr finds all rows matching the filter expression, skipping any holes
+func (s *SyncTable) QueryExpr(e Expr) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryExpr(e)
+}
+
+// TryQueryBy is QueryBy returning an error instead of panicking on invalid filters
+func (s *SyncTable) TryQueryBy(filters map[int]string) ([][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.TryQueryBy(filters)
+}
+
+// TryQueryByHoles is QueryByHoles returning an error instead of panicking on invalid filters
+func (s *SyncTable) TryQueryByHoles(filters map[int]string) ([][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.TryQueryByHoles(filters)
+}
+
+// Scan returns an iterator over all rows, skipping the deletion holes.
+// The read lock is held while the iterator runs, so yield must not write to s.
+func (s *SyncTable) Scan() func(yield func(row []string) bool) {
+	return s.locked(s.t.Scan)
+}
+
+// ScanHoles returns an iterator over all rows, including the deletion holes.
+// The read lock is held while the iterator runs, so yield must not write to s.
+func (s *SyncTable) ScanHoles() func(yield func(row []string) bool) {
+	return s.locked(s.t.ScanHoles)
+}
+
+// ScanBy returns an iterator over the rows matching every (col→val), skipping
+// any holes. Panics if filters is nil or empty. The read lock is held while
+// the iterator runs, so yield must not write to s.
+func (s *SyncTable) ScanBy(filters map[int]string) func(yield func(row []string) bool) {
+	mustFilter("ScanBy", filters)
+	return s.locked(func() func(yield func(row []string) bool) {
+		return s.t.ScanBy(filters)
+	})
+}
+
+// locked wraps the iterator made by seq in the read lock
+func (s *SyncTable) locked(seq func() func(yield func(row []string) bool)) func(yield func(row []string) bool) {
+	return func(yield func(row []string) bool) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		seq()(yield)
+	}
+}
+
+// AllPage returns up to limit rows starting at cursor and the cursor of the next page
+func (s *SyncTable) AllPage(cursor string, limit int) ([][]string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.AllPage(cursor, limit)
+}
+
+// QueryByPage returns up to limit rows matching every (col→val) starting at
+// cursor and the cursor of the next page
+func (s *SyncTable) QueryByPage(filters map[int]string, cursor string, limit int) ([][]string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryByPage(filters, cursor, limit)
+}
+
+// QueryRange loads all the rows with lo <= value < hi in column col, sorted by the value
+func (s *SyncTable) QueryRange(col int, lo, hi string) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryRange(col, lo, hi)
+}
+
+// QueryPrefix loads all the rows whose value in column col starts with prefix, sorted by the value
+func (s *SyncTable) QueryPrefix(col int, prefix string) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryPrefix(col, prefix)
+}
+
+// Width returns the number of columns of the widest row in the table
+func (s *SyncTable) Width() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Width()
+}
+
+// All returns all data from the table skipping the deletion holes
+func (s *SyncTable) All() [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.All()
+}
+
+// AllHoles returns all data from the table even if there are deletion holes
+func (s *SyncTable) AllHoles() [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.AllHoles()
+}
+
+// WriteTo writes a snapshot of the table to w
+func (s *SyncTable) WriteTo(w io.Writer) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.WriteTo(w)
+}
+
+// Save atomically writes a snapshot of the table to the file at path
+func (s *SyncTable) Save(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Save(path)
+}
+
+// Insert inserts rows to the table ignoring holes and returns their IDs
+func (s *SyncTable) Insert(data [][]string) []RowID {
+	_, ids := s.exec(op{kind: opInsert, rows: data})
+	return ids
+}
+
+// InsertHoles inserts rows even if they contain holes (0 column rows) to the table as-is
+// and returns their IDs
+func (s *SyncTable) InsertHoles(data [][]string) []RowID {
+	_, ids := s.exec(op{kind: opInsertHoles, rows: data})
+	return ids
+}
+
+// TryInsert inserts rows to the table ignoring holes and returns their IDs.
+// If a row does not fit the schema, it inserts nothing and returns a RowError,
+// if a row would be rejected by a unique key, a KeyError.
+func (s *SyncTable) TryInsert(data [][]string) ([]RowID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.t.schema.checkRows(data); err != nil {
+		return nil, err
+	}
+	if err := s.t.checkKeys(data); err != nil {
+		return nil, err
+	}
+	_, ids := s.execLocked(op{kind: opInsert, rows: data})
+	if err := s.t.walErr(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetByID loads the row with the given ID, or nil if it does not exist or was deleted
+func (s *SyncTable) GetByID(id RowID) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.GetByID(id)
+}
+
+// QueryByIDs finds the IDs of all rows matching every (col→val), skipping any holes
+func (s *SyncTable) QueryByIDs(filters map[int]string) []RowID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.QueryByIDs(filters)
+}
+
+// DeleteByID deletes the row with the given ID and reports whether it existed
+func (s *SyncTable) DeleteByID(id RowID) bool {
+	n, _ := s.exec(op{kind: opDeleteByID, ids: []RowID{id}})
+	return n > 0
+}
+
+// SetSchema attaches a schema to the table, nil removes it
+func (s *SyncTable) SetSchema(schema *Schema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.SetSchema(schema)
+}
+
+// SetNormalizer sets the normalizer of column col and re-indexes the table
+func (s *SyncTable) SetNormalizer(col int, n Normalizer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandon()
+	return s.t.SetNormalizer(col, n)
+}
+
+// SetNamedNormalizer sets the named normalizer of column col and re-indexes the table
+func (s *SyncTable) SetNamedNormalizer(col int, name string, n Normalizer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandon()
+	return s.t.SetNamedNormalizer(col, name, n)
+}
+
+// SetRangeColumns makes the buckets keep their rows sorted by the given columns
+func (s *SyncTable) SetRangeColumns(cols ...int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.t.rangeColumnsOp(cols)
+	if err != nil {
+		return err
+	}
+	s.execLocked(o)
+	return s.t.walErr()
+}
+
+// SetUniqueKeys declares the unique keys checked by the inserts, none removes them
+func (s *SyncTable) SetUniqueKeys(keys ...UniqueKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.t.uniqueKeysOp(keys)
+	if err != nil {
+		return err
+	}
+	s.execLocked(o)
+	return s.t.walErr()
+}
+
+// UniqueKeys returns the unique keys of the table
+func (s *SyncTable) UniqueKeys() []UniqueKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.UniqueKeys()
+}
+
+// Validate reports the live rows violating the unique keys, or nil if there are none
+func (s *SyncTable) Validate() []*KeyError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Validate()
+}
+
+// Remove deletes all the rows which have string val in column col
+func (s *SyncTable) Remove(col int, val string) {
+	s.exec(op{kind: opRemove, col: col, val: val})
+}
+
+// DeleteBy deletes all rows matching every (col→val).
+// Panics if filters is nil or empty.
+func (s *SyncTable) DeleteBy(filters map[int]string) {
+	mustFilter("DeleteBy", filters)
+	s.exec(op{kind: opDeleteBy, filters: filters})
+}
+
+// TryDeleteBy is DeleteBy returning an error instead of panicking on invalid
+// filters, or the error of the attached WAL if it rejects the delete
+func (s *SyncTable) TryDeleteBy(filters map[int]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.t.checkFilter(filters); err != nil {
+		return err
+	}
+	s.execLocked(op{kind: opDeleteBy, filters: filters})
+	return s.t.walErr()
+}
+
+// UpdateBy rewrites the live rows matching every (col→val) of filters with
+// the (col→val) of set and returns the number of changed rows.
+// Panics if filters is nil or empty, or set has a column beyond the schema width.
+func (s *SyncTable) UpdateBy(filters map[int]string, set map[int]string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mustUpdate(s.t.schema, filters, set)
+	n, _ := s.execLocked(op{kind: opUpdateBy, filters: filters, set: set})
+	return n
+}
+
+// Upsert inserts row unless a live row matches every (col→val) of key.
+// It reports whether row was inserted. Panics if key is nil or empty.
+func (s *SyncTable) Upsert(key map[int]string, row []string) bool {
+	mustFilter("Upsert", key)
+	n, _ := s.exec(op{kind: opUpsert, filters: key, rows: [][]string{row}})
+	return n > 0
+}
+
+// Compact compacts the table after multiple inserts, blocking all other calls.
+// It abandons a running background compaction.
+func (s *SyncTable) Compact() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandon()
+	s.t.Compact()
+}
+
+// CompactTiered merges buckets of similar size instead of rewriting the whole table
+func (s *SyncTable) CompactTiered(fanout int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.CompactTiered(fanout)
+}
+
+// SetCompactionPolicy sets the automatic compaction policy, nil disables it
+func (s *SyncTable) SetCompactionPolicy(p *CompactionPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.SetCompactionPolicy(p)
+}
+
+// CompactAsync compacts the table in the background without blocking readers.
+// The new bucket is built from a copy of the current rows while readers keep
+// using the old buckets. Writes which land meanwhile are applied to the old
+// buckets and replayed onto the new one, which is then swapped in atomically.
+// The returned channel is closed once the swap is done, or once the compaction
+// is abandoned by Write, Compact, ReadFrom, Load or Checkpoint. While a
+// compaction runs, CompactAsync returns the channel of the running one.
+func (s *SyncTable) CompactAsync() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.compacting != nil {
+		return s.compacting
+	}
+	done := make(chan struct{})
+	s.compacting = done
+	epoch := s.epoch
+	rows, ids := s.t.compactRows()
+	layout := s.t.layout
+	go func() {
+		defer close(done)
+		buck := layout.build(rows, ids)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.epoch != epoch {
+			return
+		}
+		gen := s.t.gen
+		s.t.b = []bucket{*buck}
+		s.t.gen++
+		s.t.replaying = true
+		for _, o := range s.pending {
+			s.t.apply(o)
+		}
+		s.t.replaying = false
+		s.t.compacted(gen)
+		s.pending = nil
+		s.compacting = nil
+	}()
+	return done
+}
+
+// Snapshot pins the current version of the table as a read-only View, which
+// can be read without any lock while writers continue. Pinning holds the
+// exclusive lock only to mark the buckets as shared.
+func (s *SyncTable) Snapshot() *View {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Snapshot()
+}
+
+// ReadFrom replaces the table contents with a snapshot read from r
+func (s *SyncTable) ReadFrom(r io.Reader) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandon()
+	return s.t.ReadFrom(r)
+}
+
+// Load replaces the table contents with the snapshot stored in the file at path
+func (s *SyncTable) Load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandon()
+	return s.t.Load(path)
+}
+
+// Checkpoint compacts the table, saves a snapshot and truncates the attached WAL
+func (s *SyncTable) Checkpoint(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandon()
+	return s.t.Checkpoint(path)
+}
+
+// Batch starts an empty batch of mutations of the table.
+// Commit applies it under one exclusive lock.
+func (s *SyncTable) Batch() *Batch {
+	return &Batch{commit: func(o op) ([]RowID, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		o = s.t.assign(o)
+		s.execLocked(o)
+		if err := s.t.walErr(); err != nil {
+			return nil, err
+		}
+		return o.batchIDs(), nil
+	}}
+}
+
+// SetTombstones enables or disables the tombstone log, see Table.SetTombstones
+func (s *SyncTable) SetTombstones(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.SetTombstones(enabled)
+}
+
+// DrainTombstones returns the logged tombstones in delete order and empties the log
+func (s *SyncTable) DrainTombstones() []Tombstone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.DrainTombstones()
+}
+
+// Subscribe calls fn with every following change of the table, in the order
+// the mutations were applied, see Table.Subscribe. With Block, a slow fn
+// holds up all writers, and fn must not call the table.
+func (s *SyncTable) Subscribe(fn func(Event), opts SubscribeOptions) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := s.t.subscribe(fn, opts)
+	return func() {
+		// a writer blocked on the subscriber holds the lock, so it is released first
+		sub.close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.t.unsubscribe(sub)
+	}
+}
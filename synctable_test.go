@@ -0,0 +1,186 @@
+package table
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSyncTableConcurrent(t *testing.T) {
+	const writers, readers, rounds = 4, 4, 200
+
+	var st SyncTable
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				st.Insert([][]string{{key, "live-" + key}, {key + "x", "dead-" + key}})
+				st.Remove(0, key+"x")
+				if i%50 == 0 {
+					st.Compact()
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Sprintf("w%d-%d", r, i)
+				for _, row := range st.QueryBy(map[int]string{0: key}) {
+					if len(row) != 2 || row[1] != "live-"+key {
+						t.Errorf("QueryBy returned bad row %v", row)
+					}
+				}
+				st.Get(1, "dead-"+key)
+				st.All()
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if got := len(st.All()); got != writers*rounds {
+		t.Fatalf("All has %d rows; want %d", got, writers*rounds)
+	}
+	for _, row := range st.All() {
+		if row[1] != "live-"+row[0] {
+			t.Fatalf("removed row still visible: %v", row)
+		}
+	}
+	st.Read(func(tbl *Table) {
+		if got := tbl.Count(0, "w0-0"); got != 1 {
+			t.Errorf("Count(w0-0) = %d; want 1", got)
+		}
+	})
+}
+
+func TestSyncTableCompactAsync(t *testing.T) {
+	var st SyncTable
+	for i := 0; i < 100; i++ {
+		st.Insert([][]string{{fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)}})
+	}
+	st.Remove(0, "k0")
+
+	done := st.CompactAsync()
+	if again := st.CompactAsync(); again != done {
+		t.Fatalf("second CompactAsync started another compaction")
+	}
+
+	// Readers and writers keep going while the bucket is rebuilt
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < 100; i++ {
+			if got := st.Get(0, fmt.Sprintf("k%d", i)); len(got) != 2 && i%10 != 0 {
+				t.Errorf("Get(k%d) = %v during compaction", i, got)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 10; i < 100; i += 10 {
+			st.Remove(0, fmt.Sprintf("k%d", i))
+		}
+		st.Insert([][]string{{"new", "row"}})
+	}()
+	wg.Wait()
+	<-done
+
+	// Writes made during the rebuild were replayed onto the compacted bucket
+	if got := len(st.All()); got != 99-9+1 {
+		t.Fatalf("All has %d rows after CompactAsync; want %d", got, 99-9+1)
+	}
+	for i := 0; i < 100; i += 10 {
+		if got := st.Get(0, fmt.Sprintf("k%d", i)); got != nil {
+			t.Errorf("removed row k%d visible after CompactAsync: %v", i, got)
+		}
+	}
+	if got := st.Get(0, "new"); len(got) != 2 {
+		t.Errorf("row inserted during compaction is missing")
+	}
+
+	// A synchronous Compact abandons the background one
+	st.Insert([][]string{{"late", "row"}})
+	done = st.CompactAsync()
+	st.Compact()
+	<-done
+	st.Read(func(tbl *Table) {
+		if len(tbl.b) != 1 {
+			t.Errorf("table has %d buckets after Compact; want 1", len(tbl.b))
+		}
+	})
+	if got := st.Get(0, "late"); len(got) != 2 {
+		t.Errorf("row lost by abandoned compaction")
+	}
+}
+
+func TestSyncTableCompactAsyncReuse(t *testing.T) {
+	// the rebuild must still be running when the mutations are made, which a
+	// large table makes all but certain; retry the rare runs where it is not
+	for attempt := 0; attempt < 10; attempt++ {
+		var st SyncTable
+		rows := make([][]string, 5000)
+		for i := range rows {
+			rows[i] = []string{fmt.Sprintf("k%d", i), "v"}
+		}
+		st.Insert(rows)
+		st.Insert([][]string{{"a", "v"}, {"b", "v"}, {"c", "v"}})
+
+		done := st.CompactAsync()
+		filters := map[int]string{0: "a"}
+		st.DeleteBy(filters)
+		set := map[int]string{1: "w"}
+		st.UpdateBy(map[int]string{0: "b"}, set)
+		data := [][]string{{"d", "v"}}
+		st.Insert(data)
+		var replayed bool
+		st.Read(func(*Table) {
+			replayed = len(st.pending) == 3
+			// the caller reuses its arguments before the swap
+			filters[0] = "c"
+			set[1] = "x"
+			data[0] = []string{"e", "v"}
+		})
+		<-done
+		if !replayed {
+			continue
+		}
+		if st.Get(0, "a") != nil || st.Get(0, "c") == nil {
+			t.Errorf("DeleteBy replayed the reused filters: a = %v, c = %v", st.Get(0, "a"), st.Get(0, "c"))
+		}
+		if got := st.Get(0, "b"); len(got) != 2 || got[1] != "w" {
+			t.Errorf("UpdateBy replayed the reused set: b = %v", got)
+		}
+		if st.Get(0, "d") == nil || st.Get(0, "e") != nil {
+			t.Errorf("Insert replayed the reused rows: d = %v, e = %v", st.Get(0, "d"), st.Get(0, "e"))
+		}
+		return
+	}
+	t.Skip("the compaction always finished before the mutations")
+}
+
+func TestSyncTableUpdateBySchema(t *testing.T) {
+	var st SyncTable
+	if err := st.SetSchema(&Schema{Columns: []string{"en", "fr"}}); err != nil {
+		t.Fatalf("SetSchema: %v", err)
+	}
+	st.Insert([][]string{{"cup", "tasse"}})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("UpdateBy beyond the schema width did not panic")
+			}
+		}()
+		st.UpdateBy(map[int]string{0: "cup"}, map[int]string{2: "taza"})
+	}()
+
+	// the panic released the lock and left the row as it was
+	if got := st.Get(0, "cup"); len(got) != 2 {
+		t.Errorf("row widened beyond the schema: %v", got)
+	}
+}
@@ -4,12 +4,50 @@ package table
 // Table is a memory efficient in-memory multicolumn string table aka multimap
 type Table struct {
 	b []bucket
+
+	// wal receives every mutation before it is applied, if attached
+	wal *WAL
+	// lsn is the log sequence number of the last logged mutation
+	lsn uint64
+	// policy triggers automatic compaction after mutations, if set
+	policy *CompactionPolicy
+	// schema names the columns and fixes the row width, if set
+	schema *Schema
+	// layout is how the buckets are indexed
+	layout bucketLayout
+	// next is the last assigned row ID
+	next RowID
+	// gen counts the rewrites of the buckets which move rows, invalidating page cursors
+	gen uint64
+	// epoch is random for every loaded table and salts the page cursors, so
+	// the cursors issued before a reload do not match the generations after it
+	epoch uint64
+	// tombstones are the deletes not drained yet, logged if logTombstones is set
+	tombstones    []Tombstone
+	logTombstones bool
+	// subs are the subscribers to the changes of the rows
+	subs []*subscriber
+	// replaying is set while CompactAsync replays mutations which were
+	// logged as tombstones and published already
+	replaying bool
+	// keys are the unique keys checked by the inserts
+	keys []UniqueKey
 }
 
-// Count counts the number of occurences of string val in column col
+// Count counts the number of occurences of string val in column col,
+// not counting the deleted rows
 func (b *Table) Count(col int, val string) (out int) {
-	for _, buck := range b.b {
-		out += buck.count(col, val)
+	for i := range b.b {
+		out += b.b[i].liveCount(col, val)
+	}
+	return
+}
+
+// CountHoles counts the number of occurences of string val in column col,
+// including the deleted rows which were not compacted away yet
+func (b *Table) CountHoles(col int, val string) (out int) {
+	for i := range b.b {
+		out += b.b[i].count(col, val)
 	}
 	return
 }
@@ -27,9 +65,15 @@ func (b *Table) GetAll(col int, val string) (data [][]string) {
 
 // Remove deletes all the rows which have string val in column col
 func (b *Table) Remove(col int, val string) {
-	for _, buck := range b.b {
-		buck.remove(col, val)
+	b.exec(op{kind: opRemove, col: col, val: val})
+}
+
+func (b *Table) remove(col int, val string) {
+	stone := b.tombstone(map[int]string{col: val})
+	for i := range b.b {
+		stone.add(&b.b[i], b.b[i].remove(col, val))
 	}
+	b.bury(stone, EventRemove)
 }
 
 // Get loads arbitrary single row which does have string val in column col
@@ -43,49 +87,128 @@ func (b *Table) Get(col int, val string) (data []string) {
 	return
 }
 
-// InsertHoles inserts rows even if they contain holes (0 column rows) to the table as-is
-func (b *Table) InsertHoles(data [][]string) {
-	if len(data) > 0 {
-		b.b = append(b.b, *newBucket(data))
+// InsertHoles inserts rows even if they contain holes (0 column rows) to the table as-is.
+// With a schema, rows of a different width are dropped.
+// It returns the IDs of the rows, the zero RowID for holes and dropped rows.
+func (b *Table) InsertHoles(data [][]string) []RowID {
+	o := b.assign(op{kind: opInsertHoles, rows: data})
+	if _, ok := b.exec(o); !ok {
+		return nil
 	}
+	return o.ids
 }
 
-// Insert inserts rows to the table ignoring holes
-func (b *Table) Insert(data [][]string) {
-	var in [][]string
-	for _, row := range data {
-		if len(row) > 0 {
-			in = append(in, row)
+func (b *Table) insertHoles(data [][]string, ids []RowID) {
+	var idx []int
+	for i, row := range data {
+		if len(row) == 0 || b.schema.fits(row) {
+			idx = append(idx, i)
 		}
 	}
-	if len(in) > 0 {
-		b.b = append(b.b, *newBucket(in))
+	b.appendRows(data, ids, idx)
+}
+
+// Insert inserts rows to the table ignoring holes.
+// With a schema, rows of a different width are dropped, see TryInsert, and
+// with unique keys, rows whose key is taken are dropped or replace the rows
+// holding it, see SetUniqueKeys.
+// It returns the IDs of the rows, the zero RowID for dropped rows.
+func (b *Table) Insert(data [][]string) []RowID {
+	o := b.assign(op{kind: opInsert, rows: data})
+	if _, ok := b.exec(o); !ok {
+		return nil
+	}
+	return o.ids
+}
+
+func (b *Table) insert(data [][]string, ids []RowID) int {
+	var idx []int
+	for i, row := range data {
+		if len(row) > 0 && b.schema.fits(row) {
+			idx = append(idx, i)
+		}
 	}
+	return b.appendRows(data, ids, idx)
 }
 
-// Compact compacts the table after multiple inserts
+// appendRows appends a bucket of the rows at the positions idx of data which the
+// unique keys admit and returns the number of rows inserted
+func (b *Table) appendRows(data [][]string, ids []RowID, idx []int) int {
+	b.seen(ids)
+	idx, replaced := b.admit(data, ids, idx)
+	b.record(replaced)
+	if len(idx) == 0 {
+		return 0
+	}
+	in, inIDs := make([][]string, len(idx)), make([]RowID, len(idx))
+	for j, i := range idx {
+		in[j], inIDs[j] = data[i], ids[i]
+	}
+	b.b = append(b.b, *b.layout.build(in, inIDs))
+	b.publishInsert(in, inIDs, replaced)
+	return len(in)
+}
+
+// Compact compacts the table after multiple inserts, dropping the deletion holes.
+// Rows keep their IDs. The live rows are moved into the new bucket one bucket
+// at a time, and every old bucket is released once its rows are moved, so the
+// old indices are garbage before the new index is built.
 func (b *Table) Compact() {
-	var data [][]string
-	for _, buck := range b.b {
-		data = append(data, buck.all()...)
+	defer b.compacted(b.gen)
+	b.gen++
+	n := b.countLive()
+	rows, ids := make([][]string, 0, n), make([]RowID, 0, n)
+	for i := range b.b {
+		rows, ids = b.b[i].live(rows, ids)
+		b.b[i] = bucket{}
+	}
+	b.b = nil
+	if n == 0 {
+		return
+	}
+	b.b = []bucket{*b.layout.build(rows, ids)}
+}
+
+// compactRows gathers the live rows of all buckets and their IDs into new slices for a rebuild
+func (b *Table) compactRows() (rows [][]string, ids []RowID) {
+	n := b.countLive()
+	if n == 0 {
+		return nil, nil
 	}
-	b.b = []bucket{*newBucket(data)}
+	rows, ids = make([][]string, 0, n), make([]RowID, 0, n)
+	for i := range b.b {
+		rows, ids = b.b[i].live(rows, ids)
+	}
+	return
 }
 
 // AllHoles returns all data from the table even if there are deletion holes
 func (b *Table) AllHoles() (data [][]string) {
-	for _, buck := range b.b {
-		data = append(data, buck.all()...)
+	var n int
+	for i := range b.b {
+		n += b.b[i].size()
+	}
+	if n == 0 {
+		return nil
 	}
+	data = make([][]string, 0, n)
+	b.ScanHoles()(func(row []string) bool {
+		data = append(data, row)
+		return true
+	})
 	return
 }
 
 // All returns all data from the table skipping the deletion holes
 func (b *Table) All() (out [][]string) {
-	for _, row := range b.AllHoles() {
-		if len(row) > 0 {
-			out = append(out, row)
-		}
+	n := b.countLive()
+	if n == 0 {
+		return nil
 	}
+	out = make([][]string, 0, n)
+	b.Scan()(func(row []string) bool {
+		out = append(out, row)
+		return true
+	})
 	return
 }
@@ -44,6 +44,11 @@ func TestTable_FuzzMassive(t *testing.T) {
 				count, len(all), col, val)
 		}
 
+		if holes := tbl.CountHoles(col, val); holes < count {
+			t.Fatalf("CountHoles()=%d is smaller than Count()=%d for col=%d val=%q",
+				holes, count, col, val)
+		}
+
 		// Holes must match GetAllHoles >= GetAll
 		allHoles := tbl.GetAll(col, val)
 		if len(allHoles) < len(all) {
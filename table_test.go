@@ -33,7 +33,10 @@ func TestSanityTable(t *testing.T) {
 		panic("fail")
 	}
 	table.Insert([][]string{{"4", "a"}, {"5", "b"}, {"6", "c"}})
-	if table.Count(1, "b") != 3 {
+	if table.Count(1, "b") != 2 {
+		panic("fail")
+	}
+	if table.CountHoles(1, "b") != 3 {
 		panic("fail")
 	}
 	if table.Count(1, "a") != 2 {
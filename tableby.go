@@ -4,9 +4,7 @@ package table
 // Panics if filters is nil or empty.
 // Returns nil for no matches.
 func (t *Table) QueryByHoles(filters map[int]string) [][]string {
-	if filters == nil || len(filters) == 0 {
-		panic("QueryByHoles: filters must not be nil or empty")
-	}
+	mustFilter("QueryByHoles", filters)
 	var result [][]string
 	for _, buck := range t.b {
 		rows := buck.getBy(filters)
@@ -43,11 +41,167 @@ func (t *Table) QueryBy(filters map[int]string) [][]string {
 // DeleteBy deletes all rows matching every (col→val).
 // Panics if filters is nil or empty.
 func (t *Table) DeleteBy(filters map[int]string) {
+	mustFilter("DeleteBy", filters)
+	t.exec(op{kind: opDeleteBy, filters: filters})
+}
+
+func (t *Table) deleteBy(filters map[int]string) {
+	stone := t.tombstone(filters)
+	for i := range t.b {
+		stone.add(&t.b[i], t.b[i].removeBy(filters))
+	}
+	t.bury(stone, EventDeleteBy)
+}
+
+// cloneFilters copies a filter map which is kept beyond the call, nil stays nil
+func cloneFilters(q map[int]string) map[int]string {
+	if q == nil {
+		return nil
+	}
+	c := make(map[int]string, len(q))
+	for col, val := range q {
+		c[col] = val
+	}
+	return c
+}
+
+// mustFilter panics if filters is nil or empty
+func mustFilter(method string, filters map[int]string) {
 	if filters == nil || len(filters) == 0 {
-		panic("DeleteBy: filters must not be nil or empty")
+		panic(method + ": filters must not be nil or empty")
+	}
+}
+
+// UpdateBy rewrites the live rows matching every (col→val) of filters with
+// the (col→val) of set, widening rows where needed, and returns the number
+// of changed rows. The old rows become holes and the updated rows are
+// appended as a new bucket. Panics if filters is nil or empty, or set has a
+// column beyond the schema width.
+func (t *Table) UpdateBy(filters map[int]string, set map[int]string) int {
+	mustUpdate(t.schema, filters, set)
+	n, _ := t.exec(op{kind: opUpdateBy, filters: filters, set: set})
+	return n
+}
+
+func (t *Table) updateBy(filters map[int]string, set map[int]string) int {
+	var rows, old [][]string
+	var ids []RowID
+	publish := t.hasSubscribers()
+	for i := range t.b {
+		buck := &t.b[i]
+		for _, idx := range buck.matchBy(filters) {
+			row := updateRow(buck.row(idx), set)
+			if row == nil {
+				continue
+			}
+			if publish {
+				old = append(old, buck.row(idx))
+			}
+			buck.punch(idx)
+			rows = append(rows, row)
+			ids = append(ids, buck.id(idx))
+		}
+	}
+	if len(rows) > 0 {
+		// the updated rows keep their IDs
+		t.b = append(t.b, *t.layout.build(rows, ids))
+		t.publish(Event{Kind: EventUpdate, Filters: cloneFilters(filters), Rows: rows, Old: old, IDs: ids})
+	}
+	return len(rows)
+}
+
+// updateRow returns a copy of row with set applied, or nil if set does not change row
+func updateRow(row []string, set map[int]string) []string {
+	var width = len(row)
+	var changed bool
+	for c, v := range set {
+		if c >= len(row) {
+			changed = true
+			if c >= width {
+				width = c + 1
+			}
+		} else if row[c] != v {
+			changed = true
+		}
 	}
+	if !changed {
+		return nil
+	}
+	out := make([]string, width)
+	copy(out, row)
+	for c, v := range set {
+		out[c] = v
+	}
+	return out
+}
 
+// Upsert inserts row unless a live row matches every (col→val) of key.
+// It reports whether row was inserted. Panics if key is nil or empty.
+func (t *Table) Upsert(key map[int]string, row []string) bool {
+	mustFilter("Upsert", key)
+	n, _ := t.exec(t.assign(op{kind: opUpsert, filters: key, rows: [][]string{row}}))
+	return n > 0
+}
+
+func (t *Table) upsert(key map[int]string, rows [][]string, ids []RowID) int {
+	if t.matches(key) {
+		return 0
+	}
+	if t.insert(rows, ids) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// matches reports whether a live row matches every (col→val) of key
+func (t *Table) matches(key map[int]string) bool {
 	for i := range t.b {
-		t.b[i].removeBy(filters)
+		if len(t.b[i].matchBy(key)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mustUpdate panics if filters is nil or empty or set has a negative column
+// or, with a schema, a column beyond the schema width
+func mustUpdate(schema *Schema, filters map[int]string, set map[int]string) {
+	mustFilter("UpdateBy", filters)
+	for c := range set {
+		if c < 0 {
+			panic("UpdateBy: set column must not be negative")
+		}
+		if schema != nil && c >= len(schema.Columns) {
+			panic("UpdateBy: set column beyond schema width")
+		}
+	}
+}
+
+// TryQueryByHoles is QueryByHoles returning an error instead of panicking on
+// empty filters, negative columns or columns beyond the table width
+func (t *Table) TryQueryByHoles(filters map[int]string) ([][]string, error) {
+	if err := t.checkFilter(filters); err != nil {
+		return nil, err
+	}
+	return t.QueryByHoles(filters), nil
+}
+
+// TryQueryBy is QueryBy returning an error instead of panicking on
+// empty filters, negative columns or columns beyond the table width
+func (t *Table) TryQueryBy(filters map[int]string) ([][]string, error) {
+	if err := t.checkFilter(filters); err != nil {
+		return nil, err
+	}
+	return t.QueryBy(filters), nil
+}
+
+// TryDeleteBy is DeleteBy returning an error instead of panicking on
+// empty filters, negative columns or columns beyond the table width.
+// If the attached WAL rejects the delete, its error is returned.
+func (t *Table) TryDeleteBy(filters map[int]string) error {
+	if err := t.checkFilter(filters); err != nil {
+		return err
 	}
+	t.DeleteBy(filters)
+	return t.walErr()
 }
@@ -1,6 +1,8 @@
 package table
 
 import (
+	"errors"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -107,3 +109,125 @@ func TestSanityTableBy(t *testing.T) {
 		t.Errorf("table not empty after final DeleteBy(active): %v", any)
 	}
 }
+
+func TestUpdateByUpsert(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{
+		{"u1", "admin", "active"},
+		{"u2", "member", "inactive"},
+		{"u3", "admin", "inactive"},
+	})
+	tbl.Insert([][]string{{"u4", "admin", "active"}})
+
+	// 1. UpdateBy rewrites matches across buckets and reports the count
+	if n := tbl.UpdateBy(map[int]string{1: "admin", 2: "active"}, map[int]string{2: "locked"}); n != 2 {
+		t.Fatalf("UpdateBy(admin+active) = %d; want 2", n)
+	}
+	if got := tbl.QueryBy(map[int]string{2: "active"}); got != nil {
+		t.Errorf("old rows still visible after UpdateBy: %v", got)
+	}
+	expected := [][]string{{"u1", "admin", "locked"}, {"u4", "admin", "locked"}}
+	if got := tbl.QueryBy(map[int]string{2: "locked"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("QueryBy(locked) = %v; want %v", got, expected)
+	}
+
+	// 2. Rows which already have the values do not count as changed
+	if n := tbl.UpdateBy(map[int]string{1: "admin"}, map[int]string{1: "admin"}); n != 0 {
+		t.Errorf("no-op UpdateBy = %d; want 0", n)
+	}
+
+	// 3. Setting a column beyond the row widens it
+	if n := tbl.UpdateBy(map[int]string{0: "u2"}, map[int]string{4: "x"}); n != 1 {
+		t.Fatalf("widening UpdateBy = %d; want 1", n)
+	}
+	if got := tbl.Get(0, "u2"); !reflect.DeepEqual(got, []string{"u2", "member", "inactive", "", "x"}) {
+		t.Errorf("widened row = %v", got)
+	}
+	if len(tbl.All()) != 4 {
+		t.Errorf("UpdateBy changed the number of live rows: %v", tbl.All())
+	}
+
+	// 4. Upsert only inserts when no live row matches the key
+	if tbl.Upsert(map[int]string{0: "u1"}, []string{"u1", "guest", "active"}) {
+		t.Errorf("Upsert inserted a duplicate of u1")
+	}
+	if !tbl.Upsert(map[int]string{0: "u5"}, []string{"u5", "guest", "active"}) {
+		t.Errorf("Upsert did not insert u5")
+	}
+	tbl.Remove(0, "u5")
+	if !tbl.Upsert(map[int]string{0: "u5"}, []string{"u5", "guest", "again"}) {
+		t.Errorf("Upsert did not insert u5 after its removal")
+	}
+	if got := tbl.QueryBy(map[int]string{0: "u5"}); !reflect.DeepEqual(got, [][]string{{"u5", "guest", "again"}}) {
+		t.Errorf("QueryBy(u5) = %v", got)
+	}
+}
+
+func TestTryQueryByErrors(t *testing.T) {
+	tbl := &Table{}
+
+	// 1. Empty filters on an empty table
+	if _, err := tbl.TryQueryBy(nil); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("TryQueryBy(nil) err = %v; want ErrEmptyFilter", err)
+	}
+	if err := tbl.TryDeleteBy(map[int]string{}); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("TryDeleteBy(empty) err = %v; want ErrEmptyFilter", err)
+	}
+	// an empty table has no width yet
+	if rows, err := tbl.TryQueryBy(map[int]string{7: "x"}); err != nil || rows != nil {
+		t.Errorf("TryQueryBy on empty table = %v, %v", rows, err)
+	}
+
+	tbl.Insert([][]string{{"u1", "admin"}, {"u2", "member"}})
+	tbl.Insert([][]string{{"u3", "admin", "extra"}})
+	if w := tbl.Width(); w != 3 {
+		t.Fatalf("Width = %d; want 3", w)
+	}
+
+	// 2. Negative and out of range columns
+	_, err := tbl.TryQueryBy(map[int]string{-1: "u1"})
+	var colErr *ColumnError
+	if !errors.As(err, &colErr) || colErr.Col != -1 || !errors.Is(err, ErrNegativeColumn) {
+		t.Errorf("TryQueryBy(-1) err = %v; want ColumnError wrapping ErrNegativeColumn", err)
+	}
+	if _, err := tbl.TryQueryByHoles(map[int]string{0: "u1", 3: "x"}); !errors.Is(err, ErrColumnRange) {
+		t.Errorf("TryQueryByHoles(3) err = %v; want ErrColumnRange", err)
+	}
+	if err := tbl.TryDeleteBy(map[int]string{5: "x"}); !errors.Is(err, ErrColumnRange) {
+		t.Errorf("TryDeleteBy(5) err = %v; want ErrColumnRange", err)
+	}
+
+	// 3. Valid filters behave like QueryBy and DeleteBy
+	rows, err := tbl.TryQueryBy(map[int]string{1: "admin"})
+	if err != nil || !reflect.DeepEqual(rows, [][]string{{"u1", "admin"}, {"u3", "admin", "extra"}}) {
+		t.Errorf("TryQueryBy(admin) = %v, %v", rows, err)
+	}
+	if err := tbl.TryDeleteBy(map[int]string{1: "admin"}); err != nil {
+		t.Errorf("TryDeleteBy(admin) err = %v", err)
+	}
+	if got := tbl.All(); !reflect.DeepEqual(got, [][]string{{"u2", "member"}}) {
+		t.Errorf("All after TryDeleteBy = %v", got)
+	}
+}
+
+func TestTryDeleteByWALClosed(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "table.wal"), WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl := &Table{}
+	tbl.AttachWAL(w)
+	tbl.Insert([][]string{{"u1", "admin"}})
+	w.Close()
+
+	if err := tbl.TryDeleteBy(map[int]string{0: "u1"}); err != ErrWALClosed {
+		t.Errorf("TryDeleteBy = %v; want ErrWALClosed", err)
+	}
+	st := NewSyncTable(tbl)
+	if err := st.TryDeleteBy(map[int]string{0: "u1"}); err != ErrWALClosed {
+		t.Errorf("SyncTable.TryDeleteBy = %v; want ErrWALClosed", err)
+	}
+	if got := st.Get(0, "u1"); got == nil {
+		t.Errorf("rejected delete was applied")
+	}
+}
@@ -0,0 +1,76 @@
+package table
+
+// Tombstone records one delete: the filter it was called with and the rows it
+// removed. Unlike the holes, tombstones survive Compact, so the deletes can be
+// replicated to secondary tables and search indexes or kept for audit.
+type Tombstone struct {
+	// Filters are the (col→val) of a DeleteBy, or the column and value of a
+	// Remove. They are nil for DeleteByID.
+	Filters map[int]string
+	// Rows are the removed rows, in table order
+	Rows [][]string
+	// IDs are the IDs of the removed rows, by row
+	IDs []RowID
+	// Replaced marks the rows deleted by a Replace unique key to make room
+	// for the rows of one insert. Filters are nil then.
+	Replaced bool
+}
+
+// SetTombstones enables or disables the tombstone log. While it is enabled,
+// every Remove, DeleteBy and DeleteByID which removes rows, also as part of a
+// Batch or a WAL replay, appends a Tombstone to the log until it is drained
+// by DrainTombstones. So does an insert which replaces rows by a Replace
+// unique key, with one Tombstone marked Replaced. Disabling it discards the log.
+func (t *Table) SetTombstones(enabled bool) {
+	t.logTombstones = enabled
+	if !enabled {
+		t.tombstones = nil
+	}
+}
+
+// DrainTombstones returns the logged tombstones in delete order and empties the log
+func (t *Table) DrainTombstones() []Tombstone {
+	stones := t.tombstones
+	t.tombstones = nil
+	return stones
+}
+
+// tombstone starts the tombstone of a delete, or returns nil if the log is disabled
+func (t *Table) tombstone(filters map[int]string) *Tombstone {
+	if !t.logTombstones && !t.hasSubscribers() || t.replaying {
+		return nil
+	}
+	// the caller may reuse the map
+	return &Tombstone{Filters: cloneFilters(filters)}
+}
+
+// add records the rows of the bucket at the removed positions
+func (s *Tombstone) add(b *bucket, removed []int) {
+	if s == nil {
+		return
+	}
+	for _, y := range removed {
+		s.Rows = append(s.Rows, b.at(y))
+		s.IDs = append(s.IDs, b.id(y))
+	}
+}
+
+// bury appends the tombstone to the log and publishes it as an event of the
+// given kind, unless the delete removed nothing
+func (t *Table) bury(s *Tombstone, kind EventKind) {
+	if t.record(s) {
+		t.publish(Event{Kind: kind, Filters: s.Filters, Rows: s.Rows, IDs: s.IDs})
+	}
+}
+
+// record appends the tombstone to the log and reports whether the delete
+// removed any rows
+func (t *Table) record(s *Tombstone) bool {
+	if s == nil || len(s.Rows) == 0 {
+		return false
+	}
+	if t.logTombstones {
+		t.tombstones = append(t.tombstones, *s)
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTombstones(t *testing.T) {
+	tbl := &Table{}
+	tbl.Remove(0, "cup")
+	tbl.SetTombstones(true)
+	ids := tbl.Insert([][]string{{"cup", "tasse"}, {"key", "clé"}, {"cup", "verre"}, {"bank", "banque"}})
+	bol := tbl.Insert([][]string{{"cup", "bol"}})
+
+	tbl.Remove(0, "cup")
+	filters := map[int]string{1: "clé"}
+	tbl.DeleteBy(filters)
+	filters[1] = "banque"
+	tbl.DeleteBy(map[int]string{1: "nothing"})
+	tbl.Compact()
+	tbl.DeleteByID(ids[3])
+
+	want := []Tombstone{
+		{
+			Filters: map[int]string{0: "cup"},
+			Rows:    [][]string{{"cup", "tasse"}, {"cup", "verre"}, {"cup", "bol"}},
+			IDs:     []RowID{ids[0], ids[2], bol[0]},
+		},
+		{Filters: map[int]string{1: "clé"}, Rows: [][]string{{"key", "clé"}}, IDs: []RowID{ids[1]}},
+		{Rows: [][]string{{"bank", "banque"}}, IDs: []RowID{ids[3]}},
+	}
+	if got := tbl.DrainTombstones(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DrainTombstones = %+v; want %+v", got, want)
+	}
+	if got := tbl.DrainTombstones(); got != nil {
+		t.Errorf("DrainTombstones after drain = %+v", got)
+	}
+
+	tbl.SetTombstones(false)
+	tbl.Remove(0, "bank")
+	if got := tbl.DrainTombstones(); got != nil {
+		t.Errorf("DrainTombstones when disabled = %+v", got)
+	}
+}
+
+func TestSyncTableTombstonesCompactAsync(t *testing.T) {
+	var st SyncTable
+	st.SetTombstones(true)
+	st.Insert([][]string{{"a"}, {"b"}, {"c"}})
+	done := st.CompactAsync()
+	st.Remove(0, "b")
+	<-done
+	// the delete replayed onto the compacted bucket is not logged twice
+	if got := st.DrainTombstones(); len(got) != 1 || !reflect.DeepEqual(got[0].Rows, [][]string{{"b"}}) {
+		t.Errorf("DrainTombstones = %+v", got)
+	}
+}
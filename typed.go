@@ -0,0 +1,499 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownField is returned for a field name which is not mapped to a column
+	ErrUnknownField = errors.New("table: unknown field")
+	// ErrNoCodec is returned for a mapped field of a type without a codec
+	ErrNoCodec = errors.New("table: no codec for field type")
+	// ErrCodecType is returned for a mapped field whose type its codec does not handle
+	ErrCodecType = errors.New("table: codec does not handle field type")
+)
+
+// Codec converts a field value to its canonical string form and back.
+// Equal values must encode to equal strings, because lookups compare strings.
+type Codec interface {
+	// Encode returns the canonical string form of v
+	Encode(v reflect.Value) (string, error)
+	// Decode parses s into the settable value v
+	Decode(s string, v reflect.Value) error
+}
+
+// NewCodec returns a codec of values of type V from a pair of functions
+func NewCodec[V any](encode func(V) (string, error), decode func(string) (V, error)) Codec {
+	return funcCodec[V]{encode, decode}
+}
+
+type funcCodec[V any] struct {
+	encode func(V) (string, error)
+	decode func(string) (V, error)
+}
+
+// typeChecker is implemented by the codecs which handle only some types
+type typeChecker interface {
+	// handles reports whether the codec encodes and decodes values of type t
+	handles(t reflect.Type) bool
+}
+
+func (funcCodec[V]) handles(t reflect.Type) bool {
+	return t == reflect.TypeOf((*V)(nil)).Elem()
+}
+
+func (c funcCodec[V]) Encode(v reflect.Value) (string, error) {
+	return c.encode(v.Interface().(V))
+}
+
+func (c funcCodec[V]) Decode(s string, v reflect.Value) error {
+	x, err := c.decode(s)
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(&x).Elem())
+	return nil
+}
+
+// The built-in codecs, chosen by the kind of the field
+var (
+	// StringCodec stores strings as-is
+	StringCodec Codec = stringCodec{}
+	// IntCodec stores signed integers in base 10
+	IntCodec Codec = intCodec{}
+	// UintCodec stores unsigned integers in base 10
+	UintCodec Codec = uintCodec{}
+	// FloatCodec stores floats in the shortest form which parses back exactly
+	FloatCodec Codec = floatCodec{}
+	// BoolCodec stores booleans as "true" and "false"
+	BoolCodec Codec = boolCodec{}
+	// TimeCodec stores time.Time as RFC 3339 in UTC, so equal instants match
+	TimeCodec Codec = timeCodec{}
+)
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(v reflect.Value) (string, error) { return v.String(), nil }
+
+func (stringCodec) handles(t reflect.Type) bool { return t.Kind() == reflect.String }
+
+func (stringCodec) Decode(s string, v reflect.Value) error {
+	v.SetString(s)
+	return nil
+}
+
+type intCodec struct{}
+
+func (intCodec) Encode(v reflect.Value) (string, error) { return strconv.FormatInt(v.Int(), 10), nil }
+
+func (intCodec) handles(t reflect.Type) bool { return isInt(t.Kind()) }
+
+func (intCodec) Decode(s string, v reflect.Value) error {
+	n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+	if err != nil {
+		return err
+	}
+	v.SetInt(n)
+	return nil
+}
+
+type uintCodec struct{}
+
+func (uintCodec) Encode(v reflect.Value) (string, error) {
+	return strconv.FormatUint(v.Uint(), 10), nil
+}
+
+func (uintCodec) handles(t reflect.Type) bool { return isUint(t.Kind()) }
+
+func (uintCodec) Decode(s string, v reflect.Value) error {
+	n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+	if err != nil {
+		return err
+	}
+	v.SetUint(n)
+	return nil
+}
+
+type floatCodec struct{}
+
+func (floatCodec) Encode(v reflect.Value) (string, error) {
+	f := v.Float()
+	if f == 0 {
+		// -0 and 0 are equal, so they share the canonical form
+		f = 0
+	}
+	if math.IsNaN(f) {
+		return "NaN", nil
+	}
+	return strconv.FormatFloat(f, 'g', -1, v.Type().Bits()), nil
+}
+
+func (floatCodec) handles(t reflect.Type) bool { return isFloat(t.Kind()) }
+
+func (floatCodec) Decode(s string, v reflect.Value) error {
+	f, err := strconv.ParseFloat(s, v.Type().Bits())
+	if err != nil {
+		return err
+	}
+	v.SetFloat(f)
+	return nil
+}
+
+type boolCodec struct{}
+
+func (boolCodec) Encode(v reflect.Value) (string, error) { return strconv.FormatBool(v.Bool()), nil }
+
+func (boolCodec) handles(t reflect.Type) bool { return t.Kind() == reflect.Bool }
+
+func (boolCodec) Decode(s string, v reflect.Value) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	v.SetBool(b)
+	return nil
+}
+
+type timeCodec struct{}
+
+func (timeCodec) Encode(v reflect.Value) (string, error) {
+	return v.Interface().(time.Time).UTC().Format(time.RFC3339Nano), nil
+}
+
+func (timeCodec) handles(t reflect.Type) bool { return t == timeType }
+
+func (timeCodec) Decode(s string, v reflect.Value) error {
+	tm, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(tm.UTC()))
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// defaultCodec returns the built-in codec for values of type t, or nil
+func defaultCodec(t reflect.Type) Codec {
+	if t == timeType {
+		return TimeCodec
+	}
+	switch k := t.Kind(); {
+	case k == reflect.String:
+		return StringCodec
+	case isInt(k):
+		return IntCodec
+	case isUint(k):
+		return UintCodec
+	case isFloat(k):
+		return FloatCodec
+	case k == reflect.Bool:
+		return BoolCodec
+	}
+	return nil
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uint64
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// FieldError reports a field value which could not be encoded or decoded
+type FieldError struct {
+	// Field is the Go name of the struct field
+	Field string
+	// Col is the column of the field
+	Col int
+	// Err is the codec error
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return "table: field " + e.Field + ", column " + strconv.Itoa(e.Col) + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// typedField maps a struct field to a column
+type typedField struct {
+	name  string
+	index int
+	col   int
+	codec Codec
+}
+
+// TypedTable stores values of the struct type T in a Table. Fields tagged
+//
+//	`table:"<column>[,<codec>]"`
+//
+// are mapped to columns, where column is a column number or, with a schema,
+// a column name, and codec optionally names one of the codecs passed to
+// NewTypedTable. Other fields are not stored. Values are stored in their
+// canonical string form, so the quaternary indices answer equality lookups.
+type TypedTable[T any] struct {
+	t      *Table
+	fields []typedField
+	width  int
+}
+
+// NewTypedTable maps the tagged fields of T to the columns of t. Fields without
+// a codec name in their tag use the built-in codec of their type.
+func NewTypedTable[T any](t *Table, codecs map[string]Codec) (*TypedTable[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("table: TypedTable of non-struct type %v", typ)
+	}
+	tt := &TypedTable[T]{t: t}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag, ok := f.Tag.Lookup("table")
+		if !ok || tag == "-" || !f.IsExported() {
+			continue
+		}
+		column, codecName, _ := strings.Cut(tag, ",")
+		col, err := strconv.Atoi(column)
+		if err != nil {
+			var ok bool
+			if col, ok = t.Schema().Index(column); !ok {
+				if t.Schema() == nil {
+					return nil, ErrNoSchema
+				}
+				return nil, fmt.Errorf("%w %q", ErrUnknownColumn, column)
+			}
+		}
+		if col < 0 {
+			return nil, &ColumnError{Col: col, Err: ErrNegativeColumn}
+		}
+		var codec Codec
+		if codecName != "" {
+			codec = codecs[codecName]
+		} else {
+			codec = defaultCodec(f.Type)
+		}
+		if codec == nil {
+			return nil, fmt.Errorf("%w %v of field %s", ErrNoCodec, f.Type, f.Name)
+		}
+		if tc, ok := codec.(typeChecker); ok && !tc.handles(f.Type) {
+			return nil, fmt.Errorf("%w %v of field %s", ErrCodecType, f.Type, f.Name)
+		}
+		tt.fields = append(tt.fields, typedField{name: f.Name, index: i, col: col, codec: codec})
+		if col >= tt.width {
+			tt.width = col + 1
+		}
+	}
+	if s := t.Schema(); s != nil {
+		if tt.width > len(s.Columns) {
+			return nil, &ColumnError{Col: tt.width - 1, Width: len(s.Columns), Err: ErrColumnRange}
+		}
+		tt.width = len(s.Columns)
+	}
+	return tt, nil
+}
+
+// Table returns the underlying table
+func (tt *TypedTable[T]) Table() *Table {
+	return tt.t
+}
+
+// Encode returns the row storing v. Unmapped columns are empty.
+func (tt *TypedTable[T]) Encode(v T) ([]string, error) {
+	val := reflect.ValueOf(&v).Elem()
+	row := make([]string, tt.width)
+	for _, f := range tt.fields {
+		s, err := f.codec.Encode(val.Field(f.index))
+		if err != nil {
+			return nil, &FieldError{Field: f.name, Col: f.col, Err: err}
+		}
+		row[f.col] = s
+	}
+	return row, nil
+}
+
+// Decode returns the value stored in row
+func (tt *TypedTable[T]) Decode(row []string) (v T, err error) {
+	val := reflect.ValueOf(&v).Elem()
+	for _, f := range tt.fields {
+		var s string
+		if f.col < len(row) {
+			s = row[f.col]
+		}
+		if err := f.codec.Decode(s, val.Field(f.index)); err != nil {
+			return v, &FieldError{Field: f.name, Col: f.col, Err: err}
+		}
+	}
+	return v, nil
+}
+
+// decodeAll decodes rows, skipping holes
+func (tt *TypedTable[T]) decodeAll(rows [][]string) ([]T, error) {
+	var out []T
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		v, err := tt.Decode(row)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// field returns the mapped field of the given Go name
+func (tt *TypedTable[T]) field(name string) (*typedField, error) {
+	for i := range tt.fields {
+		if tt.fields[i].name == name {
+			return &tt.fields[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownField, name)
+}
+
+// Key returns the column and the canonical string form of the field value v.
+// The value must be assignable to the field type, or be a number which the
+// field type represents exactly, so untyped constants work for any numeric
+// field while 1.9 is not truncated to an int field. Strings and booleans of
+// other named types are converted too.
+func (tt *TypedTable[T]) Key(field string, v any) (int, string, error) {
+	f, err := tt.field(field)
+	if err != nil {
+		return 0, "", err
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem().Field(f.index).Type
+	val, ok := convertExact(reflect.ValueOf(v), typ)
+	if !ok {
+		return 0, "", &FieldError{Field: f.name, Col: f.col, Err: fmt.Errorf("cannot use %T %v as %v", v, v, typ)}
+	}
+	s, err := f.codec.Encode(val)
+	if err != nil {
+		return 0, "", &FieldError{Field: f.name, Col: f.col, Err: err}
+	}
+	return f.col, s, nil
+}
+
+// filters translates (field name→value) filters to column filters
+func (tt *TypedTable[T]) filters(filters map[string]any) (map[int]string, error) {
+	if len(filters) == 0 {
+		return nil, ErrEmptyFilter
+	}
+	q := make(map[int]string, len(filters))
+	for name, v := range filters {
+		col, s, err := tt.Key(name, v)
+		if err != nil {
+			return nil, err
+		}
+		q[col] = s
+	}
+	return q, nil
+}
+
+// Insert encodes and inserts values. If a value fails to encode, nothing is inserted.
+func (tt *TypedTable[T]) Insert(values ...T) error {
+	rows := make([][]string, 0, len(values))
+	for _, v := range values {
+		row, err := tt.Encode(v)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	tt.t.Insert(rows)
+	return nil
+}
+
+// GetAll returns all values whose field equals v
+func (tt *TypedTable[T]) GetAll(field string, v any) ([]T, error) {
+	col, s, err := tt.Key(field, v)
+	if err != nil {
+		return nil, err
+	}
+	return tt.decodeAll(tt.t.GetAll(col, s))
+}
+
+// QueryBy returns all values matching every (field name→value)
+func (tt *TypedTable[T]) QueryBy(filters map[string]any) ([]T, error) {
+	q, err := tt.filters(filters)
+	if err != nil {
+		return nil, err
+	}
+	return tt.decodeAll(tt.t.QueryBy(q))
+}
+
+// DeleteBy deletes all values matching every (field name→value)
+func (tt *TypedTable[T]) DeleteBy(filters map[string]any) error {
+	q, err := tt.filters(filters)
+	if err != nil {
+		return err
+	}
+	tt.t.DeleteBy(q)
+	return nil
+}
+
+// All returns all stored values
+func (tt *TypedTable[T]) All() ([]T, error) {
+	return tt.decodeAll(tt.t.All())
+}
+
+// convertExact converts val to type t if it is assignable, of the same string
+// or bool kind, or a number which t represents exactly
+func convertExact(val reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if !val.IsValid() {
+		return val, false
+	}
+	from, to := val.Kind(), t.Kind()
+	switch {
+	case val.Type().AssignableTo(t):
+		return val.Convert(t), true
+	case from == to && (from == reflect.String || from == reflect.Bool):
+		return val.Convert(t), true
+	case !isNumber(from) || !isNumber(to):
+		return val, false
+	}
+	out := val.Convert(t)
+	// the conversion is exact if it converts back to the same value and,
+	// as the round trip wraps around, keeps the sign
+	back := out.Convert(val.Type())
+	switch {
+	case isInt(from) && back.Int() != val.Int(),
+		isUint(from) && back.Uint() != val.Uint(),
+		isFloat(from) && back.Float() != val.Float() && !math.IsNaN(val.Float()):
+		return val, false
+	}
+	if isNegative(val) != isNegative(out) {
+		return val, false
+	}
+	if isFloat(from) && !isFloat(to) && (math.IsInf(val.Float(), 0) || math.IsNaN(val.Float())) {
+		return val, false
+	}
+	return out, true
+}
+
+func isNumber(k reflect.Kind) bool {
+	return isInt(k) || isUint(k) || isFloat(k)
+}
+
+func isNegative(v reflect.Value) bool {
+	switch k := v.Kind(); {
+	case isInt(k):
+		return v.Int() < 0
+	case isFloat(k):
+		return v.Float() < 0
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package table
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type typedUser struct {
+	Name    string    `table:"name"`
+	Age     int64     `table:"age"`
+	Score   float64   `table:"score"`
+	Admin   bool      `table:"admin"`
+	Joined  time.Time `table:"joined"`
+	Level   Level     `table:"level,level"`
+	Ignored string
+}
+
+type Level int
+
+func TestTypedTable(t *testing.T) {
+	tbl := &Table{}
+	if err := tbl.SetSchema(&Schema{Columns: []string{"name", "age", "score", "admin", "joined", "level"}}); err != nil {
+		t.Fatal(err)
+	}
+	levels := []string{"low", "high"}
+	users, err := NewTypedTable[typedUser](tbl, map[string]Codec{
+		"level": NewCodec(func(l Level) (string, error) {
+			return levels[l], nil
+		}, func(s string) (Level, error) {
+			for i, l := range levels {
+				if l == s {
+					return Level(i), nil
+				}
+			}
+			return 0, errors.New("bad level " + strconv.Quote(s))
+		}),
+	})
+	if err != nil {
+		t.Fatalf("NewTypedTable: %v", err)
+	}
+
+	joined := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	alice := typedUser{Name: "alice", Age: 30, Score: 0.5, Admin: true, Joined: joined, Level: 1}
+	bob := typedUser{Name: "bob", Age: 30, Score: -0.0, Joined: joined.Add(time.Hour)}
+	if err := users.Insert(alice, bob, typedUser{Name: "carol", Age: 41, Score: 2}); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	// 1. Values are stored in canonical string form
+	if got := tbl.Get(0, "bob"); !reflect.DeepEqual(got, []string{"bob", "30", "0", "false", "2024-03-01T13:00:00Z", "low"}) {
+		t.Errorf("stored row = %q", got)
+	}
+
+	// 2. Typed lookups, with untyped constants and other time zones
+	if got, err := users.GetAll("Age", 30); err != nil || !reflect.DeepEqual(got, []typedUser{alice, bob}) {
+		t.Errorf("GetAll(Age, 30) = %v, %v", got, err)
+	}
+	cet := time.FixedZone("CET", 3600)
+	if got, err := users.QueryBy(map[string]any{"Joined": joined.In(cet), "Admin": true}); err != nil || !reflect.DeepEqual(got, []typedUser{alice}) {
+		t.Errorf("QueryBy(Joined, Admin) = %v, %v", got, err)
+	}
+	if got, err := users.QueryBy(map[string]any{"Score": 0.0}); err != nil || len(got) != 1 || got[0].Name != "bob" {
+		t.Errorf("QueryBy(Score 0) = %v, %v", got, err)
+	}
+	if got, err := users.GetAll("Level", Level(1)); err != nil || len(got) != 1 || got[0].Name != "alice" {
+		t.Errorf("GetAll(Level high) = %v, %v", got, err)
+	}
+
+	// 3. Errors
+	if _, err := users.GetAll("Ignored", "x"); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("GetAll(Ignored) err = %v; want ErrUnknownField", err)
+	}
+	var fieldErr *FieldError
+	if _, err := users.GetAll("Age", "thirty"); !errors.As(err, &fieldErr) || fieldErr.Field != "Age" {
+		t.Errorf("GetAll(Age, string) err = %v; want FieldError", err)
+	}
+	// values are converted only if the field type represents them exactly
+	for _, c := range []struct {
+		field string
+		v     any
+	}{{"Name", 65}, {"Age", 1.9}, {"Age", uint64(math.MaxUint64)}, {"Score", int64(1<<53 + 1)}, {"Age", math.NaN()}} {
+		if _, err := users.GetAll(c.field, c.v); !errors.As(err, &fieldErr) {
+			t.Errorf("GetAll(%s, %T %v) err = %v; want FieldError", c.field, c.v, c.v, err)
+		}
+	}
+	if got, err := users.GetAll("Age", 30.0); err != nil || len(got) != 2 {
+		t.Errorf("GetAll(Age, 30.0) = %v, %v", got, err)
+	}
+	if _, err := users.QueryBy(nil); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("QueryBy(nil) err = %v; want ErrEmptyFilter", err)
+	}
+	tbl.Insert([][]string{{"dave", "old", "1", "true", "", "low"}})
+	if _, err := users.All(); !errors.As(err, &fieldErr) || fieldErr.Col != 1 {
+		t.Errorf("All with a bad row err = %v; want FieldError in column 1", err)
+	}
+	tbl.Remove(0, "dave")
+	if _, err := NewTypedTable[struct {
+		X []int `table:"0"`
+	}](tbl, nil); !errors.Is(err, ErrNoCodec) {
+		t.Errorf("NewTypedTable of []int field err = %v; want ErrNoCodec", err)
+	}
+	if _, err := NewTypedTable[struct {
+		X string `table:"0,level"`
+	}](tbl, map[string]Codec{"level": NewCodec(func(i int) (string, error) {
+		return strconv.Itoa(i), nil
+	}, strconv.Atoi)}); !errors.Is(err, ErrCodecType) {
+		t.Errorf("NewTypedTable of int codec on string field err = %v; want ErrCodecType", err)
+	}
+	if _, err := NewTypedTable[struct {
+		X string `table:"0,int"`
+	}](tbl, map[string]Codec{"int": IntCodec}); !errors.Is(err, ErrCodecType) {
+		t.Errorf("NewTypedTable of IntCodec on string field err = %v; want ErrCodecType", err)
+	}
+	if _, err := NewTypedTable[struct {
+		X int `table:"nope"`
+	}](tbl, nil); !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("NewTypedTable of unknown column err = %v; want ErrUnknownColumn", err)
+	}
+
+	// 4. Deletes
+	if err := users.DeleteBy(map[string]any{"Age": int8(30)}); err != nil {
+		t.Fatalf("DeleteBy: %v", err)
+	}
+	if got, err := users.All(); err != nil || len(got) != 1 || got[0].Name != "carol" {
+		t.Errorf("All after DeleteBy = %v, %v", got, err)
+	}
+}
+
+func TestTypedTableColumns(t *testing.T) {
+	// Without a schema, columns are numbers and the row is as wide as the highest one
+	type point struct {
+		X uint8   `table:"2"`
+		Y float32 `table:"0"`
+	}
+	points, err := NewTypedTable[point](&Table{}, nil)
+	if err != nil {
+		t.Fatalf("NewTypedTable: %v", err)
+	}
+	row, err := points.Encode(point{X: 255, Y: 1.5})
+	if err != nil || !reflect.DeepEqual(row, []string{"1.5", "", "255"}) {
+		t.Fatalf("Encode = %q, %v", row, err)
+	}
+	if _, err := points.Decode([]string{"0", "", "256"}); err == nil {
+		t.Errorf("Decode of uint8 overflow succeeded")
+	}
+	if _, err := NewTypedTable[struct {
+		X int `table:"x"`
+	}](&Table{}, nil); !errors.Is(err, ErrNoSchema) {
+		t.Errorf("named column without schema err = %v; want ErrNoSchema", err)
+	}
+}
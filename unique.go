@@ -0,0 +1,312 @@
+package table
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrDuplicateKey is wrapped by a KeyError
+	ErrDuplicateKey = errors.New("table: duplicate unique key")
+	// ErrConflict is returned for a unique key with an unknown OnConflict
+	ErrConflict = errors.New("table: unknown conflict action")
+)
+
+// Conflict selects what an insert does with a row whose unique key is taken
+type Conflict int
+
+const (
+	// Reject drops the new row
+	Reject Conflict = iota
+	// Replace deletes the rows holding the key and inserts the new row. The
+	// deleted rows are reported with the insert, see Event.Replaced.
+	Replace
+)
+
+// UniqueKey declares that no two live rows share the values of Columns,
+// compared in their normalized form. Rows too short to have every column of
+// the key are not constrained by it.
+type UniqueKey struct {
+	// Columns are the key columns, one for a simple key, more for a composite one
+	Columns []int
+	// OnConflict is what an insert does with a row whose key is taken
+	OnConflict Conflict
+}
+
+// KeyError reports rows sharing the values of a unique key
+type KeyError struct {
+	// Columns are the columns of the key
+	Columns []int
+	// Values are the values of the key
+	Values []string
+	// Row is the position of the offending row in the rows passed in, or -1
+	Row int
+	// IDs are the IDs of the stored rows holding the key
+	IDs []RowID
+}
+
+func (e *KeyError) Error() string {
+	var b strings.Builder
+	b.WriteString(ErrDuplicateKey.Error())
+	for i, c := range e.Columns {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString("column " + strconv.Itoa(c) + " = " + strconv.Quote(e.Values[i]))
+	}
+	if e.Row >= 0 {
+		b.WriteString(" in row " + strconv.Itoa(e.Row))
+	}
+	return b.String()
+}
+
+func (e *KeyError) Unwrap() error {
+	return ErrDuplicateKey
+}
+
+// SetUniqueKeys declares the unique keys of the table, none removes them.
+// From then on Insert, InsertHoles, TryInsert, Upsert and Batch inserts check
+// every row against the live rows and the earlier rows of the same call: a
+// Reject key drops the row, returning the zero RowID for it, and a Replace
+// key deletes the rows holding the key first. The replaced rows are not
+// reported as a DeleteBy: subscribers get them in the Replaced rows of the
+// EventInsert, and the tombstone log in one Tombstone marked Replaced.
+// UpdateBy is not checked, and neither are the rows already stored, see Validate.
+// If the attached WAL rejects the change, its error is returned and the keys
+// are left unchanged.
+func (t *Table) SetUniqueKeys(keys ...UniqueKey) error {
+	o, err := t.uniqueKeysOp(keys)
+	if err != nil {
+		return err
+	}
+	t.exec(o)
+	return t.walErr()
+}
+
+// uniqueKeysOp validates the unique keys and returns the op setting them
+func (t *Table) uniqueKeysOp(keys []UniqueKey) (op, error) {
+	var copied []UniqueKey
+	for _, k := range keys {
+		if len(k.Columns) == 0 {
+			return op{}, ErrEmptyFilter
+		}
+		if k.OnConflict != Reject && k.OnConflict != Replace {
+			return op{}, ErrConflict
+		}
+		for _, c := range k.Columns {
+			if c < 0 {
+				return op{}, &ColumnError{Col: c, Width: t.Width(), Err: ErrNegativeColumn}
+			}
+		}
+		copied = append(copied, UniqueKey{Columns: append([]int{}, k.Columns...), OnConflict: k.OnConflict})
+	}
+	return op{kind: opUniqueKeys, keys: copied}, nil
+}
+
+// UniqueKeys returns the unique keys of the table
+func (t *Table) UniqueKeys() []UniqueKey {
+	keys := make([]UniqueKey, len(t.keys))
+	for i, k := range t.keys {
+		keys[i] = UniqueKey{Columns: append([]int{}, k.Columns...), OnConflict: k.OnConflict}
+	}
+	return keys
+}
+
+// value returns the normalized values of the key in row, encoded as one
+// string, or false if the row lacks a key column
+func (t *Table) value(k *UniqueKey, row []string) (string, bool) {
+	var b strings.Builder
+	for _, c := range k.Columns {
+		if c >= len(row) {
+			return "", false
+		}
+		v := t.layout.key(c, row[c])
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.WriteString(v)
+	}
+	return b.String(), true
+}
+
+// filters returns the filter matching the rows which share the key of row
+func (k *UniqueKey) filters(row []string) map[int]string {
+	q := make(map[int]string, len(k.Columns))
+	for _, c := range k.Columns {
+		q[c] = row[c]
+	}
+	return q
+}
+
+// holders returns the IDs of the live rows sharing the key of row. Every
+// bucket is probed by matchBy, which counts the candidates with countExisting
+// and checks them against the row.
+func (t *Table) holders(k *UniqueKey, row []string) (ids []RowID) {
+	q := k.filters(row)
+	for i := range t.b {
+		for _, y := range t.b[i].matchBy(q) {
+			ids = append(ids, t.b[i].id(y))
+		}
+	}
+	return
+}
+
+// admit checks the rows at the positions idx of data against the unique keys
+// and returns the positions which may be inserted. Rejected and replaced rows
+// of the insert get the zero ID in ids. Stored rows held by a Replace key are
+// deleted and returned in a tombstone, if tombstones or subscribers need it.
+func (t *Table) admit(data [][]string, ids []RowID, idx []int) ([]int, *Tombstone) {
+	if len(t.keys) == 0 {
+		return idx, nil
+	}
+	replaced := t.tombstone(nil)
+	if replaced != nil {
+		replaced.Replaced = true
+	}
+	// seen maps the key values of the admitted rows to their position in data
+	seen := make([]map[string]int, len(t.keys))
+	for k := range seen {
+		seen[k] = make(map[string]int)
+	}
+	dropped := make(map[int]bool)
+	drop := func(i int) {
+		dropped[i] = true
+		ids[i] = 0
+		for k := range t.keys {
+			if v, ok := t.value(&t.keys[k], data[i]); ok && seen[k][v] == i {
+				delete(seen[k], v)
+			}
+		}
+	}
+	var admitted []int
+	for _, i := range idx {
+		row := data[i]
+		if len(row) == 0 {
+			admitted = append(admitted, i)
+			continue
+		}
+		if t.rejects(row, seen) {
+			ids[i] = 0
+			continue
+		}
+		for k := range t.keys {
+			key := &t.keys[k]
+			v, ok := t.value(key, row)
+			if !ok || key.OnConflict != Replace {
+				continue
+			}
+			if j, dup := seen[k][v]; dup {
+				drop(j)
+			}
+			q := key.filters(row)
+			for b := range t.b {
+				replaced.add(&t.b[b], t.b[b].removeBy(q))
+			}
+		}
+		for k := range t.keys {
+			if v, ok := t.value(&t.keys[k], row); ok {
+				seen[k][v] = i
+			}
+		}
+		admitted = append(admitted, i)
+	}
+	out := admitted[:0]
+	for _, i := range admitted {
+		if !dropped[i] {
+			out = append(out, i)
+		}
+	}
+	return out, replaced
+}
+
+// rejects reports whether a Reject key of row is held by a live row or an
+// earlier row of the insert
+func (t *Table) rejects(row []string, seen []map[string]int) bool {
+	for k := range t.keys {
+		key := &t.keys[k]
+		if key.OnConflict != Reject {
+			continue
+		}
+		v, ok := t.value(key, row)
+		if !ok {
+			continue
+		}
+		if _, dup := seen[k][v]; dup || len(t.holders(key, row)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKeys returns a KeyError for the first row which a Reject key would drop
+func (t *Table) checkKeys(data [][]string) error {
+	seen := make([]map[string]int, len(t.keys))
+	for k := range seen {
+		seen[k] = make(map[string]int)
+	}
+	for i, row := range data {
+		if len(row) == 0 || !t.schema.fits(row) {
+			continue
+		}
+		for k := range t.keys {
+			key := &t.keys[k]
+			v, ok := t.value(key, row)
+			if !ok {
+				continue
+			}
+			if key.OnConflict == Reject {
+				_, dup := seen[k][v]
+				if held := t.holders(key, row); dup || len(held) > 0 {
+					return &KeyError{Columns: key.Columns, Values: key.values(row), Row: i, IDs: held}
+				}
+			}
+			seen[k][v] = i
+		}
+	}
+	return nil
+}
+
+// values returns the values of the key in row
+func (k *UniqueKey) values(row []string) []string {
+	vals := make([]string, len(k.Columns))
+	for i, c := range k.Columns {
+		vals[i] = row[c]
+	}
+	return vals
+}
+
+// Validate reports the live rows violating the unique keys, one KeyError per
+// shared key value in table order, or nil if there are none. Rows can violate
+// a key which was set after they were stored, or which they got by UpdateBy.
+func (t *Table) Validate() (errs []*KeyError) {
+	for k := range t.keys {
+		key := &t.keys[k]
+		groups := make(map[string]*KeyError)
+		var order []*KeyError
+		for i := range t.b {
+			buck := &t.b[i]
+			for y := 0; y < buck.size(); y++ {
+				row := buck.row(y)
+				v, ok := t.value(key, row)
+				if len(row) == 0 || !ok {
+					continue
+				}
+				e := groups[v]
+				if e == nil {
+					e = &KeyError{Columns: key.Columns, Values: key.values(row), Row: -1}
+					groups[v] = e
+					order = append(order, e)
+				}
+				e.IDs = append(e.IDs, buck.id(y))
+			}
+		}
+		for _, e := range order {
+			if len(e.IDs) > 1 {
+				errs = append(errs, e)
+			}
+		}
+	}
+	return
+}
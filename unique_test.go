@@ -0,0 +1,224 @@
+package table
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUniqueReject(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{{"cat", "chat"}})
+	if err := tbl.SetUniqueKeys(UniqueKey{Columns: []int{0}}, UniqueKey{Columns: []int{1}}); err != nil {
+		t.Fatalf("SetUniqueKeys: %v", err)
+	}
+
+	// 1) Rows whose key is taken by a stored row or an earlier row get no ID
+	ids := tbl.Insert([][]string{{"cat", "matou"}, {"dog", "chien"}, {"hound", "chien"}, {"cow"}})
+	if ids[0] != 0 || ids[1] == 0 || ids[2] != 0 || ids[3] == 0 {
+		t.Errorf("Insert IDs = %v; want the first and third rejected", ids)
+	}
+	want := [][]string{{"cat", "chat"}, {"dog", "chien"}, {"cow"}}
+	if got := tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All = %v; want %v", got, want)
+	}
+
+	// 2) A deleted row does not hold its key
+	tbl.Remove(0, "cat")
+	if ids := tbl.Insert([][]string{{"cat", "matou"}}); ids[0] == 0 {
+		t.Errorf("Insert after Remove rejected the row")
+	}
+
+	// 3) TryInsert reports the conflict and inserts nothing
+	_, err := tbl.TryInsert([][]string{{"owl", "hibou"}, {"pup", "chien"}})
+	var ke *KeyError
+	if !errors.As(err, &ke) || !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("TryInsert error = %v; want a KeyError", err)
+	}
+	if ke.Row != 1 || !reflect.DeepEqual(ke.Columns, []int{1}) || !reflect.DeepEqual(ke.Values, []string{"chien"}) || !reflect.DeepEqual(ke.IDs, ids[1:2]) {
+		t.Errorf("KeyError = %+v", ke)
+	}
+	if tbl.Get(0, "owl") != nil {
+		t.Errorf("TryInsert inserted rows despite the conflict")
+	}
+}
+
+func TestUniqueReplace(t *testing.T) {
+	tbl := &Table{}
+	tbl.SetTombstones(true)
+	tbl.SetUniqueKeys(UniqueKey{Columns: []int{0, 1}, OnConflict: Replace})
+	old := tbl.Insert([][]string{{"bank", "fr", "banque"}, {"bank", "de", "Bank"}})
+	events, _ := subscribeEvents(t, tbl.Subscribe, SubscribeOptions{Backpressure: Buffer})
+
+	// The composite key replaces only the row sharing both columns, and the
+	// later of two rows of one insert wins
+	ids := tbl.Insert([][]string{{"bank", "fr", "rive"}, {"bank", "fr", "berge"}})
+	if ids[0] != 0 || ids[1] == 0 {
+		t.Errorf("Insert IDs = %v; want the first replaced by the second", ids)
+	}
+	want := [][]string{{"bank", "de", "Bank"}, {"bank", "fr", "berge"}}
+	if got := tbl.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All = %v; want %v", got, want)
+	}
+	stones := tbl.DrainTombstones()
+	if len(stones) != 1 || !reflect.DeepEqual(stones[0].IDs, old[:1]) || !stones[0].Replaced || stones[0].Filters != nil {
+		t.Errorf("DrainTombstones = %+v; want the replaced row", stones)
+	}
+
+	// Subscribers get the replaced row with the insert, not as a delete
+	tbl.Insert([][]string{{"end"}})
+	wantEvents := []Event{
+		{Kind: EventInsert, Rows: [][]string{{"bank", "fr", "berge"}}, IDs: ids[1:], Replaced: [][]string{{"bank", "fr", "banque"}}, ReplacedIDs: old[:1]},
+		{Kind: EventInsert, Rows: [][]string{{"end"}}, IDs: []RowID{ids[1] + 1}},
+	}
+	for i := range wantEvents {
+		if got := nextEvent(t, events); !reflect.DeepEqual(got, wantEvents[i]) {
+			t.Errorf("event %d = %+v; want %+v", i, got, wantEvents[i])
+		}
+	}
+	if got := tbl.Validate(); got != nil {
+		t.Errorf("Validate = %+v; want nil", got)
+	}
+}
+
+func TestUniqueRecover(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "table.wal")
+	snapPath := filepath.Join(dir, "table.tbl")
+	w, err := OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl, err := Recover(snapPath, w)
+	if err != nil {
+		t.Fatalf("Recover of empty state: %v", err)
+	}
+	tbl.Insert([][]string{{"cat", "chat"}, {"dog", "chien"}})
+	if err := tbl.SetUniqueKeys(UniqueKey{Columns: []int{0}, OnConflict: Replace}); err != nil {
+		t.Fatalf("SetUniqueKeys: %v", err)
+	}
+	ids := tbl.Insert([][]string{{"cat", "matou"}, {"owl", "hibou"}})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Replaying the log restores the keys and replaces the same rows
+	w, err = OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	defer w.Close()
+	recovered, err := Recover(snapPath, w)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if got, want := recovered.AllHoles(), tbl.AllHoles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered %v; want %v", got, want)
+	}
+	if got, want := recovered.UniqueKeys(), tbl.UniqueKeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered UniqueKeys = %+v; want %+v", got, want)
+	}
+	if got := recovered.GetByID(ids[0]); !reflect.DeepEqual(got, []string{"cat", "matou"}) {
+		t.Errorf("recovered GetByID(%d) = %q", ids[0], got)
+	}
+	// the recovered keys still hold
+	recovered.Insert([][]string{{"owl", "chouette"}})
+	if got := recovered.GetAll(0, "owl"); !reflect.DeepEqual(got, [][]string{{"owl", "chouette"}}) {
+		t.Errorf("recovered GetAll(owl) = %q; want the replacement only", got)
+	}
+}
+
+func TestUniqueKeysWALClosed(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "table.wal"), WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl := &Table{}
+	tbl.AttachWAL(w)
+	w.Close()
+
+	if err := tbl.SetUniqueKeys(UniqueKey{Columns: []int{0}}); err != ErrWALClosed {
+		t.Errorf("SetUniqueKeys = %v; want ErrWALClosed", err)
+	}
+	st := NewSyncTable(tbl)
+	if err := st.SetUniqueKeys(UniqueKey{Columns: []int{0}}); err != ErrWALClosed {
+		t.Errorf("SyncTable.SetUniqueKeys = %v; want ErrWALClosed", err)
+	}
+	if got := st.UniqueKeys(); len(got) != 0 {
+		t.Errorf("UniqueKeys = %+v after rejected changes", got)
+	}
+}
+
+func TestUniqueValidate(t *testing.T) {
+	tbl := &Table{}
+	ids := tbl.Insert([][]string{{"en", "fr"}, {"one", "un"}, {"a", "un"}, {"two", "deux"}})
+	more := tbl.Insert([][]string{{"one", "une"}})
+	tbl.SetNormalizer(0, FoldCase)
+	tbl.SetUniqueKeys(UniqueKey{Columns: []int{0}}, UniqueKey{Columns: []int{1}})
+
+	// Keys set after the rows were stored are not enforced until Validate,
+	// and the snapshot keeps them
+	var buf bytes.Buffer
+	if _, err := tbl.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := &Table{}
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := loaded.UniqueKeys(); !reflect.DeepEqual(got, tbl.UniqueKeys()) {
+		t.Errorf("UniqueKeys after ReadFrom = %+v; want %+v", got, tbl.UniqueKeys())
+	}
+	want := []*KeyError{
+		{Columns: []int{0}, Values: []string{"one"}, Row: -1, IDs: []RowID{ids[1], more[0]}},
+		{Columns: []int{1}, Values: []string{"un"}, Row: -1, IDs: []RowID{ids[1], ids[2]}},
+	}
+	if got := loaded.Validate(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate = %+v; want %+v", got, want)
+	}
+	if ids := tbl.Insert([][]string{{"TWO", "zwei"}}); ids[0] != 0 {
+		t.Errorf("Insert of a key differing only in case was not rejected")
+	}
+
+	loaded.DeleteByID(ids[2])
+	if got := loaded.Validate(); len(got) != 1 {
+		t.Errorf("Validate after delete = %+v; want one violation", got)
+	}
+}
+
+func TestUniqueKeysErrors(t *testing.T) {
+	tbl := &Table{}
+	if err := tbl.SetUniqueKeys(UniqueKey{}); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("SetUniqueKeys without columns = %v", err)
+	}
+	if err := tbl.SetUniqueKeys(UniqueKey{Columns: []int{-1}}); !errors.Is(err, ErrNegativeColumn) {
+		t.Errorf("SetUniqueKeys with a negative column = %v", err)
+	}
+	if err := tbl.SetUniqueKeys(UniqueKey{Columns: []int{0}, OnConflict: 9}); !errors.Is(err, ErrConflict) {
+		t.Errorf("SetUniqueKeys with an unknown conflict action = %v", err)
+	}
+	if got := tbl.UniqueKeys(); len(got) != 0 {
+		t.Errorf("UniqueKeys after errors = %+v", got)
+	}
+}
+
+func TestSyncTableUnique(t *testing.T) {
+	var st SyncTable
+	st.SetUniqueKeys(UniqueKey{Columns: []int{0}})
+	st.Insert([][]string{{"a", "1"}, {"b", "2"}})
+	done := st.CompactAsync()
+	st.Insert([][]string{{"a", "3"}, {"c", "4"}})
+	<-done
+	want := [][]string{{"a", "1"}, {"b", "2"}, {"c", "4"}}
+	if got := st.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All = %v; want %v", got, want)
+	}
+	if _, err := st.TryInsert([][]string{{"b"}}); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("TryInsert = %v; want ErrDuplicateKey", err)
+	}
+	if got := st.Validate(); got != nil {
+		t.Errorf("Validate = %+v", got)
+	}
+}
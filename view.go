@@ -0,0 +1,164 @@
+package table
+
+import "io"
+
+// View is a read-only version of a table pinned by Snapshot. It keeps seeing
+// the rows and deletion holes of that moment while the table is written on,
+// so long scans and multi-query reports read one consistent version.
+//
+// Pinning copies only the bucket list. Inserts and compactions build new
+// buckets and leave the pinned ones alone, while the first delete from a
+// pinned bucket copies its hole bitmap before marking the row, copy-on-write.
+type View struct {
+	t *Table
+}
+
+// Snapshot pins the current version of the table as a read-only View.
+// The View may be read while the table is modified, but on a plain Table
+// not from another goroutine than the writer, see SyncTable.Snapshot.
+func (b *Table) Snapshot() *View {
+	for i := range b.b {
+		b.b[i].shared = true
+	}
+	return &View{t: &Table{
+		b:      append([]bucket(nil), b.b...),
+		lsn:    b.lsn,
+		schema: b.schema,
+		layout: b.layout,
+		next:   b.next,
+		keys:   b.keys,
+		gen:    b.gen,
+		epoch:  b.epoch,
+	}}
+}
+
+// Count counts the number of occurences of string val in column col,
+// not counting the deleted rows
+func (v *View) Count(col int, val string) int {
+	return v.t.Count(col, val)
+}
+
+// CountHoles counts the number of occurences of string val in column col, including the deleted rows
+func (v *View) CountHoles(col int, val string) int {
+	return v.t.CountHoles(col, val)
+}
+
+// GetAll loads all the rows which have string val in column col
+func (v *View) GetAll(col int, val string) [][]string {
+	return v.t.GetAll(col, val)
+}
+
+// Get loads arbitrary single row which does have string val in column col
+func (v *View) Get(col int, val string) []string {
+	return v.t.Get(col, val)
+}
+
+// UniqueKeys returns the unique keys of the table
+func (v *View) UniqueKeys() []UniqueKey {
+	return v.t.UniqueKeys()
+}
+
+// Validate reports the live rows violating the unique keys, or nil if there are none
+func (v *View) Validate() []*KeyError {
+	return v.t.Validate()
+}
+
+// Schema returns the schema of the table when it was pinned, or nil
+func (v *View) Schema() *Schema {
+	return v.t.Schema()
+}
+
+// Width returns the number of columns of the widest row in the view
+func (v *View) Width() int {
+	return v.t.Width()
+}
+
+// QueryByName finds all rows matching every (column name→val), skipping any holes.
+// Panics if filters is nil or empty, or names a column not in the schema.
+func (v *View) QueryByName(filters map[string]string) [][]string {
+	return v.t.QueryByName(filters)
+}
+
+// QueryBy finds all rows matching every (col→val), skipping any holes.
+// Panics if filters is nil or empty.
+// Returns nil for no matches.
+func (v *View) QueryBy(filters map[int]string) [][]string {
+	return v.t.QueryBy(filters)
+}
+
+// QueryByHoles finds all rows matching every (col→val), including holes
+func (v *View) QueryByHoles(filters map[int]string) [][]string {
+	return v.t.QueryByHoles(filters)
+}
+
+// TryQueryBy is QueryBy returning an error instead of panicking on invalid filters
+func (v *View) TryQueryBy(filters map[int]string) ([][]string, error) {
+	return v.t.TryQueryBy(filters)
+}
+
+// QueryExpr finds all rows matching the expression, skipping any holes
+func (v *View) QueryExpr(e Expr) [][]string {
+	return v.t.QueryExpr(e)
+}
+
+// QueryRange loads all the rows with lo <= value < hi in column col, sorted by the value
+func (v *View) QueryRange(col int, lo, hi string) [][]string {
+	return v.t.QueryRange(col, lo, hi)
+}
+
+// QueryPrefix loads all the rows whose value in column col starts with prefix, sorted by the value
+func (v *View) QueryPrefix(col int, prefix string) [][]string {
+	return v.t.QueryPrefix(col, prefix)
+}
+
+// All returns all rows, skipping the deletion holes
+func (v *View) All() [][]string {
+	return v.t.All()
+}
+
+// AllHoles returns all rows, including the deletion holes
+func (v *View) AllHoles() [][]string {
+	return v.t.AllHoles()
+}
+
+// Scan returns an iterator over all rows, skipping the deletion holes
+func (v *View) Scan() func(yield func(row []string) bool) {
+	return v.t.Scan()
+}
+
+// ScanHoles returns an iterator over all rows, including the deletion holes
+func (v *View) ScanHoles() func(yield func(row []string) bool) {
+	return v.t.ScanHoles()
+}
+
+// ScanBy returns an iterator over the rows matching every (col→val), skipping any holes
+func (v *View) ScanBy(filters map[int]string) func(yield func(row []string) bool) {
+	return v.t.ScanBy(filters)
+}
+
+// AllPage returns up to limit rows starting at cursor and the cursor of the next page
+func (v *View) AllPage(cursor string, limit int) ([][]string, string, error) {
+	return v.t.AllPage(cursor, limit)
+}
+
+// QueryByPage returns up to limit rows matching every (col→val) starting at
+// cursor and the cursor of the next page
+func (v *View) QueryByPage(filters map[int]string, cursor string, limit int) ([][]string, string, error) {
+	return v.t.QueryByPage(filters, cursor, limit)
+}
+
+// GetByID loads the row with the given ID, or nil if it does not exist or was deleted
+func (v *View) GetByID(id RowID) []string {
+	return v.t.GetByID(id)
+}
+
+// QueryByIDs finds the IDs of all rows matching every (col→val), skipping any holes
+func (v *View) QueryByIDs(filters map[int]string) []RowID {
+	return v.t.QueryByIDs(filters)
+}
+
+// WriteTo writes a snapshot file of the pinned version to w. Together with
+// an attached WAL, this is a consistent backup taken while writes continue.
+func (v *View) WriteTo(w io.Writer) (int64, error) {
+	return v.t.WriteTo(w)
+}
@@ -0,0 +1,79 @@
+package table
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	tbl := &Table{}
+	tbl.Insert([][]string{{"cup", "tasse"}, {"key", "clé"}, {"bank", "banque"}})
+	tbl.Insert([][]string{{"cup", "verre"}})
+	view := tbl.Snapshot()
+	want := tbl.All()
+
+	tbl.Remove(0, "cup")
+	tbl.DeleteBy(map[int]string{1: "clé"})
+	tbl.UpdateBy(map[int]string{0: "bank"}, map[int]string{1: "rive"})
+	tbl.Insert([][]string{{"coin", "pièce"}})
+	tbl.SetNormalizer(1, FoldCase)
+	tbl.Compact()
+
+	if got := view.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("view All = %q; want %q", got, want)
+	}
+	if got := view.GetAll(0, "cup"); len(got) != 2 {
+		t.Errorf("view GetAll(cup) = %q", got)
+	}
+	if got := view.QueryBy(map[int]string{1: "banque"}); len(got) != 1 {
+		t.Errorf("view QueryBy(banque) = %q", got)
+	}
+	if got := view.Get(0, "coin"); got != nil {
+		t.Errorf("view sees a later insert: %q", got)
+	}
+	if got, want := tbl.All(), [][]string{{"bank", "rive"}, {"coin", "pièce"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("table All = %q; want %q", got, want)
+	}
+
+	// a view can be saved and loaded like the table it was pinned from
+	var buf bytes.Buffer
+	if _, err := view.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	loaded := &Table{}
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if got := loaded.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded view All = %q; want %q", got, want)
+	}
+}
+
+func TestSyncTableSnapshot(t *testing.T) {
+	var st SyncTable
+	for i := 0; i < 100; i++ {
+		st.Insert([][]string{{"row", string(rune('a' + i%26))}})
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 26; i++ {
+			st.Remove(1, string(rune('a'+i)))
+			st.Snapshot()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		view := st.Snapshot()
+		n := len(view.All())
+		// the rows of one view do not change while the table is written
+		for j := 0; j < 5; j++ {
+			if got := len(view.QueryBy(map[int]string{0: "row"})); got != n {
+				t.Fatalf("view changed from %d to %d rows", n, got)
+			}
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,394 @@
+package table
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy selects when the write-ahead log is flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncEveryOp fsyncs the log after every logged mutation
+	SyncEveryOp SyncPolicy = iota
+	// SyncBatch fsyncs the log after every WALOptions.BatchSize logged mutations
+	SyncBatch
+	// SyncPeriodic fsyncs the log every WALOptions.Interval in the background
+	SyncPeriodic
+)
+
+// WALOptions configures a write-ahead log
+type WALOptions struct {
+	// Sync is the fsync policy
+	Sync SyncPolicy
+	// BatchSize is the number of mutations per fsync for SyncBatch, default 64
+	BatchSize int
+	// Interval is the fsync period for SyncPeriodic, default one second
+	Interval time.Duration
+}
+
+var (
+	// ErrWALClosed is returned when logging to a closed write-ahead log
+	ErrWALClosed = errors.New("table: write-ahead log is closed")
+	// ErrWALMagic is returned when the file is not a write-ahead log
+	ErrWALMagic = errors.New("table: not a write-ahead log")
+	// ErrWALVersion is returned when the log format version is not supported
+	ErrWALVersion = errors.New("table: unsupported write-ahead log version")
+	// ErrWALCorrupt is returned when a log record before the last one fails its
+	// checksum, or a checksummed record cannot be decoded
+	ErrWALCorrupt = errors.New("table: corrupt write-ahead log record")
+)
+
+// walMagic identifies a write-ahead log file
+const walMagic = "NLWL"
+
+// walVersion is the log format version written by OpenWAL
+const walVersion = 1
+
+// walFileHeader is the size of the file header: magic and format version
+const walFileHeader = int64(len(walMagic) + 4)
+
+// walHeader is the size of the record header: payload length and payload checksum
+const walHeader = 8
+
+// WAL is an append-only write-ahead log of table mutations.
+// Each record is framed by its length and checksum, so a record torn by a
+// crash is detected and discarded when the log is opened.
+type WAL struct {
+	mu      sync.Mutex
+	f       *os.File
+	opts    WALOptions
+	end     int64
+	lsn     uint64
+	pending int
+	err     error
+	scratch bytes.Buffer
+	enc     snapshotWriter
+	stop    chan struct{}
+	stopped sync.Once
+	done    chan struct{}
+}
+
+// OpenWAL opens or creates the write-ahead log at path.
+// A torn record at the end of the log is truncated away. A damaged record
+// before the last one fails with ErrWALCorrupt, leaving the file untouched.
+func OpenWAL(path string, opts WALOptions) (*WAL, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 64
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{f: f, opts: opts}
+	w.enc.w = bufio.NewWriter(&w.scratch)
+	err = w.header()
+	if err == nil {
+		w.end, w.lsn, err = w.scan(func(uint64, op) {})
+	}
+	if err == nil {
+		err = f.Truncate(w.end)
+	}
+	if err == nil {
+		_, err = f.Seek(w.end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if opts.Sync == SyncPeriodic {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// header checks the magic and format version at the start of the log. A new
+// log, or one whose header was torn while it was created, gets a new header.
+func (w *WAL) header() error {
+	var want [walFileHeader]byte
+	copy(want[:], walMagic)
+	binary.LittleEndian.PutUint32(want[len(walMagic):], walVersion)
+	st, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	got := make([]byte, walFileHeader)
+	if st.Size() < walFileHeader {
+		got = got[:st.Size()]
+	}
+	if _, err := w.f.ReadAt(got, 0); err != nil {
+		return err
+	}
+	if int64(len(got)) < walFileHeader {
+		if !bytes.Equal(got, want[:len(got)]) {
+			return ErrWALMagic
+		}
+		if _, err := w.f.WriteAt(want[:], 0); err != nil {
+			return err
+		}
+		return w.f.Sync()
+	}
+	if string(got[:len(walMagic)]) != walMagic {
+		return ErrWALMagic
+	}
+	if binary.LittleEndian.Uint32(got[len(walMagic):]) != walVersion {
+		return ErrWALVersion
+	}
+	return nil
+}
+
+// scan reads the valid prefix of the log, calling fn for every record.
+// It returns the offset after the last valid record and its sequence number,
+// or ErrWALCorrupt if a record other than the last one is damaged.
+func (w *WAL) scan(fn func(lsn uint64, o op)) (end int64, lsn uint64, err error) {
+	st, err := w.f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	end = walFileHeader
+	r := bufio.NewReader(io.NewSectionReader(w.f, end, st.Size()-end))
+	var header [walHeader]byte
+	for {
+		if _, err = io.ReadFull(r, header[:]); err != nil {
+			return end, lsn, nil
+		}
+		size := binary.LittleEndian.Uint32(header[:4])
+		if end+walHeader+int64(size) > st.Size() {
+			return end, lsn, nil
+		}
+		payload := make([]byte, size)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return end, lsn, nil
+		}
+		if crc32.Checksum(payload, snapshotCrc) != binary.LittleEndian.Uint32(header[4:]) {
+			// only the last record can be torn by a crash, a damaged record
+			// followed by others must not discard them
+			if end+walHeader+int64(size) == st.Size() {
+				return end, lsn, nil
+			}
+			return end, lsn, ErrWALCorrupt
+		}
+		s := &snapshotReader{buf: payload}
+		seq := s.uvarint()
+		o := s.op()
+		if s.err != nil || s.off != len(payload) {
+			return end, lsn, ErrWALCorrupt
+		}
+		fn(seq, o)
+		end += walHeader + int64(size)
+		lsn = seq
+	}
+}
+
+// append logs a mutation and returns its log sequence number
+func (w *WAL) append(o op) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.scratch.Reset()
+	w.scratch.Write(make([]byte, walHeader))
+	w.enc.crc, w.enc.n = 0, 0
+	w.enc.uvarint(w.lsn + 1)
+	w.enc.op(o)
+	if w.enc.err == nil {
+		w.enc.err = w.enc.w.Flush()
+	}
+	if w.enc.err != nil {
+		w.err = w.enc.err
+		return 0, w.err
+	}
+	frame := w.scratch.Bytes()
+	binary.LittleEndian.PutUint32(frame[:4], uint32(len(frame)-walHeader))
+	binary.LittleEndian.PutUint32(frame[4:], w.enc.crc)
+	if _, err := w.f.Write(frame); err != nil {
+		w.err = err
+		return 0, err
+	}
+	w.end += int64(len(frame))
+	w.lsn++
+	w.pending++
+	switch w.opts.Sync {
+	case SyncEveryOp:
+		w.err = w.sync()
+	case SyncBatch:
+		if w.pending >= w.opts.BatchSize {
+			w.err = w.sync()
+		}
+	}
+	return w.lsn, w.err
+}
+
+func (w *WAL) sync() error {
+	if w.pending == 0 {
+		return nil
+	}
+	w.pending = 0
+	return w.f.Sync()
+}
+
+func (w *WAL) syncLoop() {
+	defer close(w.done)
+	tick := time.NewTicker(w.opts.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-tick.C:
+			w.Sync()
+		}
+	}
+}
+
+// Sync flushes all logged mutations to stable storage
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.sync()
+	return w.err
+}
+
+// Err returns the first error which made the log reject mutations
+func (w *WAL) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Replay applies the logged mutations newer than the table's last logged
+// mutation to t, in log order, without logging them again
+func (w *WAL) Replay(t *Table) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, _, err := w.scan(func(lsn uint64, o op) {
+		if lsn > t.lsn {
+			t.apply(o)
+			t.lsn = lsn
+		}
+	})
+	return err
+}
+
+// Truncate discards all logged mutations, after they were made durable by a snapshot
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	// the file header stays
+	if err := w.f.Truncate(walFileHeader); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(walFileHeader, io.SeekStart); err != nil {
+		return err
+	}
+	w.end = walFileHeader
+	w.pending = 0
+	return w.f.Sync()
+}
+
+// Close syncs and closes the log. Mutations of a table still attached to it are rejected afterwards.
+func (w *WAL) Close() error {
+	if w.stop != nil {
+		// concurrent calls stop the sync loop once and all wait for it
+		w.stopped.Do(func() { close(w.stop) })
+		<-w.done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == ErrWALClosed {
+		return nil
+	}
+	err := w.err
+	if err == nil {
+		err = w.sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.err = ErrWALClosed
+	return err
+}
+
+// AttachWAL logs every following mutation of the table to w before applying it.
+// If logging fails the mutation is not applied and the error is reported by w.Err.
+// Passing nil detaches the log.
+func (b *Table) AttachWAL(w *WAL) {
+	b.wal = w
+	if w != nil {
+		w.mu.Lock()
+		if w.lsn < b.lsn {
+			w.lsn = b.lsn
+		}
+		w.mu.Unlock()
+	}
+}
+
+// walErr returns the error which made the attached WAL reject mutations, if any
+func (b *Table) walErr() error {
+	if b.wal == nil {
+		return nil
+	}
+	return b.wal.Err()
+}
+
+// Checkpoint compacts the table, atomically writes a snapshot to the file at
+// path and truncates the attached WAL, whose mutations the snapshot now contains
+func (b *Table) Checkpoint(path string) error {
+	b.Compact()
+	if err := b.Save(path); err != nil {
+		return err
+	}
+	if b.wal == nil {
+		return nil
+	}
+	return b.wal.Truncate()
+}
+
+// Recover loads the snapshot at path, if it exists, replays the mutations
+// logged after it from w and attaches w to the recovered table.
+// norms are the normalizers by column of the logged table, see SetNormalizer,
+// so the replayed deletes and unique keys match rows as they did when logged.
+// They are anonymous, so a snapshot indexed by normalizers is re-indexed, see
+// RecoverNamed.
+func Recover(path string, w *WAL, norms ...Normalizer) (*Table, error) {
+	return RecoverNamed(path, w, nil, norms...)
+}
+
+// RecoverNamed is Recover with the names of the normalizers by column, see
+// SetNamedNormalizer. If the snapshot was indexed by normalizers of the same
+// names, its index is used as is.
+func RecoverNamed(path string, w *WAL, names []string, norms ...Normalizer) (*Table, error) {
+	// without a snapshot, the replayed table is a reload too
+	t := &Table{epoch: newEpoch()}
+	// names has an entry for every column of norms
+	named := make([]string, len(norms))
+	copy(named, names)
+	t.layout.norms, t.layout.names = norms, named
+	if err := t.Load(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := w.Replay(t); err != nil {
+		return nil, err
+	}
+	t.AttachWAL(w)
+	return t, nil
+}
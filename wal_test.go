@@ -0,0 +1,284 @@
+package table
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// walWorkload applies one of each logged mutation to tbl
+func walWorkload(tbl *Table) {
+	tbl.Insert([][]string{
+		{"u1", "admin", "active"},
+		{"u2", "member", "inactive"},
+		{"u3", "admin", "inactive"},
+	})
+	tbl.InsertHoles([][]string{{"u4", "guest", "active"}, nil})
+	tbl.Remove(0, "u2")
+	tbl.DeleteBy(map[int]string{1: "admin", 2: "inactive"})
+	tbl.Insert([][]string{{"u5", "member", "active"}})
+	tbl.UpdateBy(map[int]string{0: "u5"}, map[int]string{2: "inactive", 3: "note"})
+	tbl.Upsert(map[int]string{0: "u6"}, []string{"u6", "guest", "active"})
+	tbl.Upsert(map[int]string{0: "u1"}, []string{"u1", "guest", "active"})
+	batch := tbl.Batch()
+	batch.Remove(0, "u4")
+	batch.DeleteBy(map[int]string{0: "u6"})
+	batch.Insert([][]string{{"u7", "member", "active"}, nil})
+	batch.Commit()
+}
+
+func TestWALRecover(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "table.wal")
+	snapPath := filepath.Join(dir, "table.tbl")
+
+	for _, policy := range []SyncPolicy{SyncEveryOp, SyncBatch, SyncPeriodic} {
+		os.Remove(logPath)
+		w, err := OpenWAL(logPath, WALOptions{Sync: policy, BatchSize: 2, Interval: time.Millisecond})
+		if err != nil {
+			t.Fatalf("OpenWAL: %v", err)
+		}
+		tbl, err := Recover(snapPath, w)
+		if err != nil {
+			t.Fatalf("Recover of empty state: %v", err)
+		}
+		walWorkload(tbl)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		// 1) Mutations of a table with a closed log are rejected
+		tbl.Insert([][]string{{"lost"}})
+		if tbl.Get(0, "lost") != nil {
+			t.Errorf("policy %d: mutation applied after WAL was closed", policy)
+		}
+
+		// 2) Replaying the log reproduces the physical table
+		w, err = OpenWAL(logPath, WALOptions{Sync: policy})
+		if err != nil {
+			t.Fatalf("OpenWAL: %v", err)
+		}
+		recovered, err := Recover(snapPath, w)
+		if err != nil {
+			t.Fatalf("Recover: %v", err)
+		}
+		if got, want := recovered.AllHoles(), tbl.AllHoles(); !reflect.DeepEqual(got, want) {
+			t.Errorf("policy %d: recovered %v; want %v", policy, got, want)
+		}
+		w.Close()
+	}
+}
+
+func TestWALTornRecord(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "table.wal")
+	w, err := OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl := &Table{}
+	tbl.AttachWAL(w)
+	tbl.Insert([][]string{{"a", "1"}, {"b", "2"}})
+	tbl.Insert([][]string{{"c", "3"}})
+	w.Close()
+
+	// Cut the last record in half, as if the process died while writing it
+	st, _ := os.Stat(logPath)
+	if err := os.Truncate(logPath, st.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	w, err = OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL of torn log: %v", err)
+	}
+	defer w.Close()
+	recovered, err := Recover(filepath.Join(t.TempDir(), "missing.tbl"), w)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if got := recovered.All(); !reflect.DeepEqual(got, [][]string{{"a", "1"}, {"b", "2"}}) {
+		t.Fatalf("recovered %v; want only the first insert", got)
+	}
+
+	// New records go after the last valid one
+	recovered.Insert([][]string{{"d", "4"}})
+	w.Close()
+	w, _ = OpenWAL(logPath, WALOptions{})
+	again, err := Recover(filepath.Join(t.TempDir(), "missing.tbl"), w)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if got := again.All(); !reflect.DeepEqual(got, [][]string{{"a", "1"}, {"b", "2"}, {"d", "4"}}) {
+		t.Fatalf("recovered %v after appending to a repaired log", got)
+	}
+	w.Close()
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "table.wal")
+	w, err := OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl := &Table{}
+	tbl.AttachWAL(w)
+	tbl.Insert([][]string{{"a", "1"}})
+	st, _ := os.Stat(logPath)
+	first := st.Size()
+	tbl.Insert([][]string{{"b", "2"}})
+	tbl.Insert([][]string{{"c", "3"}})
+	w.Close()
+	image, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A damaged record followed by valid ones is reported, not truncated away
+	damaged := append([]byte(nil), image...)
+	damaged[first+walHeader+2] ^= 0x10
+	if err := os.WriteFile(logPath, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenWAL(logPath, WALOptions{}); err != ErrWALCorrupt {
+		t.Errorf("OpenWAL of a log damaged in the middle = %v; want ErrWALCorrupt", err)
+	}
+	if st, _ := os.Stat(logPath); st.Size() != int64(len(image)) {
+		t.Errorf("damaged log was truncated to %d of %d bytes", st.Size(), len(image))
+	}
+
+	// A damaged last record is a torn write and is discarded
+	damaged = append([]byte(nil), image...)
+	damaged[len(damaged)-2] ^= 0x10
+	if err := os.WriteFile(logPath, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err = OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL of a log with a torn last record: %v", err)
+	}
+	defer w.Close()
+	recovered, err := Recover(filepath.Join(t.TempDir(), "missing.tbl"), w)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if got := recovered.All(); !reflect.DeepEqual(got, [][]string{{"a", "1"}, {"b", "2"}}) {
+		t.Errorf("recovered %v; want the first two inserts", got)
+	}
+}
+
+func TestWALHeader(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "table.wal")
+	w, err := OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	w.Close()
+	image, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(image)) != walFileHeader || string(image[:len(walMagic)]) != walMagic {
+		t.Fatalf("new log = %q; want only the header", image)
+	}
+
+	for _, c := range []struct {
+		name  string
+		image []byte
+		err   error
+	}{
+		{"torn", image[:3], nil},
+		{"magic", append([]byte("XXXX"), image[4:]...), ErrWALMagic},
+		{"version", append(append([]byte(nil), image[:4]...), 0xff, 0, 0, 0), ErrWALVersion},
+		{"foreign", []byte("NO"), ErrWALMagic},
+	} {
+		path := filepath.Join(dir, c.name+".wal")
+		if err := os.WriteFile(path, c.image, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		w, err := OpenWAL(path, WALOptions{})
+		if err != c.err {
+			t.Errorf("%s: OpenWAL = %v; want %v", c.name, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		w.Close()
+		// a header torn while the log was created is written anew
+		if got, _ := os.ReadFile(path); !reflect.DeepEqual(got, image) {
+			t.Errorf("%s: log = %q; want %q", c.name, got, image)
+		}
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "table.wal")
+	snapPath := filepath.Join(dir, "table.tbl")
+
+	w, err := OpenWAL(logPath, WALOptions{})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	tbl, _ := Recover(snapPath, w)
+	walWorkload(tbl)
+
+	// 1) A snapshot written without truncating the log, as if we crashed
+	// in the middle of Checkpoint, must not make the log replay twice
+	if err := tbl.Save(snapPath); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	tbl.Insert([][]string{{"u6", "guest", "inactive"}})
+	w.Close()
+	w, _ = OpenWAL(logPath, WALOptions{})
+	recovered, err := Recover(snapPath, w)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if got, want := recovered.AllHoles(), tbl.AllHoles(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered %v; want %v", got, want)
+	}
+
+	// 2) Checkpoint truncates the log
+	if err := recovered.Checkpoint(snapPath); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	if st, _ := os.Stat(logPath); st.Size() != walFileHeader {
+		t.Fatalf("log has %d bytes after Checkpoint; want only the header", st.Size())
+	}
+	recovered.Remove(0, "u6")
+	w.Close()
+
+	w, _ = OpenWAL(logPath, WALOptions{})
+	defer w.Close()
+	final, err := Recover(snapPath, w)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if got, want := final.All(), recovered.All(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered %v after Checkpoint; want %v", got, want)
+	}
+}
+
+func TestWALConcurrentClose(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "table.wal"), WALOptions{Sync: SyncPeriodic, Interval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Err(); err != ErrWALClosed {
+		t.Errorf("Err after Close = %v; want ErrWALClosed", err)
+	}
+}